@@ -0,0 +1,207 @@
+package connpool
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zlyuancn/connpool/clock"
+)
+
+const (
+	// 熔断后进入半开状态的时间
+	defBreakerOpenTimeout = time.Second * 5
+	// 半开状态下允许同时探测的数量
+	defBreakerHalfOpenProbes = 1
+)
+
+// 熔断器配置, 作用于创建conn
+type BreakerConfig struct {
+	FailureThreshold int           // 连续创建失败多少次后熔断, 小于1表示不启用熔断
+	OpenTimeout      time.Duration // 熔断后经过多久进入半开状态进行探测
+	HalfOpenProbes   int           // 半开状态下允许同时探测的数量
+}
+
+func (b *BreakerConfig) check() {
+	if b.OpenTimeout < 1 {
+		b.OpenTimeout = defBreakerOpenTimeout
+	}
+	if b.HalfOpenProbes < 1 {
+		b.HalfOpenProbes = defBreakerHalfOpenProbes
+	}
+}
+
+// 熔断器状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 关闭, 正常创建conn
+	BreakerOpen                         // 打开, 拒绝创建conn
+	BreakerHalfOpen                     // 半开, 允许少量探测
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	}
+	return "unknown"
+}
+
+// 后端不可用错误, 包含最后一次 Creator 返回的错误
+type BackendUnavailableError struct {
+	LastErr error // 最后一次 Creator 返回的错误
+}
+
+func (e *BackendUnavailableError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrBackendUnavailable, e.LastErr)
+}
+
+func (e *BackendUnavailableError) Is(target error) bool {
+	return target == ErrBackendUnavailable
+}
+
+func (e *BackendUnavailableError) Unwrap() error {
+	return e.LastErr
+}
+
+// 熔断器, 未启用时为nil, 所有方法对nil安全
+type breaker struct {
+	conf  *BreakerConfig
+	clock clock.Clock
+
+	mx       sync.Mutex
+	state    BreakerState
+	fails    int       // 连续失败次数
+	openTime time.Time // 进入打开状态的时间
+	probing  int       // 半开状态下正在探测的数量
+	round    int       // 进入半开状态的次数, 用于识别过期的探测
+	lastErr  error     // 最后一次失败的错误
+}
+
+// 一次创建的许可, 由 allow 发放, 必须通过 onResult 或 release 归还且只生效一次
+type breakerPermit struct {
+	probe bool // 是否为半开状态下的探测, 只有探测归还时释放探测名额
+	round int  // 发放探测时的半开轮次
+	done  bool // 已归还
+}
+
+func newBreaker(conf *BreakerConfig, clk clock.Clock) *breaker {
+	if conf.FailureThreshold < 1 {
+		return nil
+	}
+	return &breaker{conf: conf, clock: clk}
+}
+
+// 申请创建conn, 不允许创建时返回错误. 熔断器未启用时返回的许可为nil
+func (b *breaker) allow() (*breakerPermit, error) {
+	if b == nil {
+		return nil, nil
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.clock.Since(b.openTime) < b.conf.OpenTimeout {
+			return nil, &BackendUnavailableError{LastErr: b.lastErr}
+		}
+		b.state = BreakerHalfOpen
+		b.round++
+		b.probing = 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing >= b.conf.HalfOpenProbes {
+			return nil, &BackendUnavailableError{LastErr: b.lastErr}
+		}
+		b.probing++
+		return &breakerPermit{probe: true, round: b.round}, nil
+	}
+	return &breakerPermit{}, nil
+}
+
+// 归还许可并记录创建结果, 重复归还时忽略
+func (b *breaker) onResult(p *breakerPermit, err error) {
+	if b == nil {
+		return
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if !b.releaseLocked(p) {
+		return
+	}
+
+	// 半开状态只由本轮的探测决定结果
+	if b.state == BreakerHalfOpen && (!p.probe || p.round != b.round) {
+		return
+	}
+
+	if err == nil {
+		b.state = BreakerClosed
+		b.fails = 0
+		return
+	}
+
+	b.lastErr = err
+	b.fails++
+	if b.state == BreakerHalfOpen || b.fails >= b.conf.FailureThreshold {
+		b.state = BreakerOpen
+		b.openTime = b.clock.Now()
+	}
+}
+
+// 归还许可但不记录结果, 用于连接池关闭或调用者取消等与后端无关的情况
+func (b *breaker) release(p *breakerPermit) {
+	if b == nil {
+		return
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.releaseLocked(p)
+}
+
+// 标记许可已归还, 本轮的探测释放探测名额. 已经归还过时返回false
+func (b *breaker) releaseLocked(p *breakerPermit) bool {
+	if p.done {
+		return false
+	}
+	p.done = true
+	if p.probe && p.round == b.round && b.state == BreakerHalfOpen && b.probing > 0 {
+		b.probing--
+	}
+	return true
+}
+
+// 如果后端已知不可用返回错误, 否则返回nil. 半开状态下等待探测结果, 不认为不可用
+func (b *breaker) unavailable() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.state == BreakerOpen && b.clock.Since(b.openTime) < b.conf.OpenTimeout {
+		return &BackendUnavailableError{LastErr: b.lastErr}
+	}
+	return nil
+}
+
+// 当前状态
+func (b *breaker) getState() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.state
+}
@@ -0,0 +1,131 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zlyuancn/connpool/clock"
+)
+
+func TestBreaker(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MinIdle = 1
+	conf.BatchIncrement = 1
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	conf.Retry.InitialDelay = time.Millisecond * 10
+	conf.Retry.MaxAttempts = 2
+	conf.Breaker.FailureThreshold = 2
+	conf.Breaker.OpenTimeout = time.Millisecond * 300
+
+	createErr := errors.New("connection refused")
+	fail := int32(1)
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		if atomic.LoadInt32(&fail) == 1 {
+			return nil, createErr
+		}
+		return testConn{}, nil
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	time.Sleep(time.Millisecond * 100) // 等待创建失败达到熔断阈值
+	require.Equal(t, BreakerOpen, p.Stats().BreakerState)
+
+	s := time.Now()
+	_, err = p.Get(context.Background())
+	require.True(t, errors.Is(err, ErrBackendUnavailable))
+	require.Equal(t, createErr, errors.Unwrap(err))
+	require.True(t, time.Since(s) < time.Millisecond*100) // 立即失败
+
+	// 后端恢复后半开探测成功
+	atomic.StoreInt32(&fail, 0)
+	time.Sleep(time.Millisecond * 300)
+	_, err = p.Get(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, BreakerClosed, p.Stats().BreakerState)
+}
+
+func TestBreakerHalfOpenFail(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	b := newBreaker(&BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Second, HalfOpenProbes: 1}, clk)
+	permit, err := b.allow()
+	require.Nil(t, err)
+	b.onResult(permit, errors.New(""))
+	_, err = b.allow()
+	require.NotNil(t, err)
+	require.NotNil(t, b.unavailable())
+
+	clk.Advance(time.Second)
+	require.Nil(t, b.unavailable())
+	probe, err := b.allow() // 半开探测
+	require.Nil(t, err)
+	_, err = b.allow()
+	require.NotNil(t, err)
+	b.onResult(probe, errors.New("")) // 探测失败重新熔断
+	require.Equal(t, BreakerOpen, b.getState())
+	require.NotNil(t, b.unavailable())
+}
+
+// 只有本轮的探测才释放探测名额, 许可只生效一次
+func TestBreakerPermit(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	b := newBreaker(&BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Second, HalfOpenProbes: 1}, clk)
+	stale, err := b.allow() // 熔断前发放的许可
+	require.Nil(t, err)
+	permit, err := b.allow()
+	require.Nil(t, err)
+	b.onResult(permit, errors.New(""))
+	require.Equal(t, BreakerOpen, b.getState())
+
+	clk.Advance(time.Second)
+	probe, err := b.allow()
+	require.Nil(t, err)
+	b.onResult(stale, errors.New("")) // 不是探测, 不影响半开状态
+	require.Equal(t, BreakerHalfOpen, b.getState())
+	_, err = b.allow()
+	require.NotNil(t, err)
+
+	b.release(probe)
+	b.release(probe) // 重复归还被忽略
+	require.Equal(t, 0, b.probing)
+
+	probe2, err := b.allow()
+	require.Nil(t, err)
+	b.onResult(probe2, nil)
+	require.Equal(t, BreakerClosed, b.getState())
+	b.onResult(probe, errors.New("")) // 已归还的许可被忽略
+	require.Equal(t, BreakerClosed, b.getState())
+	require.Equal(t, 0, b.fails)
+}
+
+// 连接超时视为创建失败, 不需要等待 Creator 返回
+func TestBreakerConnectTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	started := make(chan struct{}, 1)
+	block := make(chan struct{})
+	conf := makeTestConfig()
+	conf.Clock = clk
+	conf.MinIdle = 1
+	conf.ConnectTimeout = time.Second
+	conf.Retry.MaxAttempts = 1
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	conf.Breaker.FailureThreshold = 1
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		started <- struct{}{}
+		<-block // 忽略ctx
+		return nil, errors.New("")
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+	defer close(block)
+
+	<-started
+	clk.Advance(time.Second)
+	require.Eventually(t, func() bool { return p.Stats().BreakerState == BreakerOpen }, time.Second, time.Millisecond*10)
+}
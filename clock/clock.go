@@ -0,0 +1,103 @@
+// 连接池使用的时钟, 测试时可以使用 Fake 手动推进时间
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 时钟
+type Clock interface {
+	// 当前时间
+	Now() time.Time
+	// 从t到现在经过的时间
+	Since(t time.Time) time.Duration
+	// 创建一个定时器
+	NewTimer(d time.Duration) Timer
+	// 创建一个周期定时器
+	NewTicker(d time.Duration) Ticker
+}
+
+// 定时器, 参考 time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// 周期定时器, 参考 time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// 获取系统时钟
+func Real() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+func (realClock) NewTimer(d time.Duration) Timer  { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time   { return t.t.C }
+func (t realTicker) Stop()                 { t.t.Stop() }
+func (t realTicker) Reset(d time.Duration) { t.t.Reset(d) }
+
+// 创建在时钟经过d后超时的ctx, 超时后 Err 返回 context.DeadlineExceeded.
+// 系统时钟时等同于 context.WithTimeout, 其它时钟不会设置ctx的 Deadline
+func WithTimeout(parent context.Context, c Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := c.(realClock); ok {
+		return context.WithTimeout(parent, d)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	tc := &timeoutCtx{Context: ctx}
+	t := c.NewTimer(d)
+	go func() {
+		select {
+		case <-t.C():
+			tc.mx.Lock()
+			tc.timeout = true
+			tc.mx.Unlock()
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	// 同步停止定时器, 以免取消后仍被 Fake.Waiters 计入
+	return tc, func() {
+		t.Stop()
+		cancel()
+	}
+}
+
+type timeoutCtx struct {
+	context.Context
+	mx      sync.Mutex
+	timeout bool
+}
+
+func (c *timeoutCtx) Err() error {
+	err := c.Context.Err()
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if err != nil && c.timeout {
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 手动推进的时钟, 只有调用 Advance 或 Set 时时间才会前进, 到期的定时器会按到期时间依次触发
+type Fake struct {
+	mx      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter // 未到期的定时器
+	changed chan struct{} // 定时器数量变化的信号, 变化时关闭并重新创建
+}
+
+var _ Clock = (*Fake)(nil)
+
+// 创建一个手动推进的时钟, now为初始时间, 为零值时使用当前时间
+func NewFake(now time.Time) *Fake {
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return &Fake{now: now, changed: make(chan struct{})}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeWaiter{clock: f, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return fakeTimer{t}
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: ticker的周期必须大于0")
+	}
+	t := &fakeWaiter{clock: f, ch: make(chan time.Time, 1), period: d}
+	t.Reset(d)
+	return fakeTicker{t}
+}
+
+// 推进时间, 期间到期的定时器会按到期时间依次触发, d小于0时不做任何事
+func (f *Fake) Advance(d time.Duration) {
+	if d < 0 {
+		return
+	}
+
+	f.mx.Lock()
+	end := f.now.Add(d)
+	for len(f.waiters) > 0 && !f.waiters[0].deadline.After(end) {
+		w := f.waiters[0]
+		f.now = w.deadline
+		f.removeLocked(w)
+		w.fire(f.now)
+		if w.period > 0 {
+			w.deadline = f.now.Add(w.period)
+			f.addLocked(w)
+		}
+	}
+	f.now = end
+	f.mx.Unlock()
+}
+
+// 将时间设置为t, t早于当前时间时不做任何事
+func (f *Fake) Set(t time.Time) {
+	f.Advance(t.Sub(f.Now()))
+}
+
+// 当前未到期的定时器数量
+func (f *Fake) Waiters() int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return len(f.waiters)
+}
+
+// 阻塞直到未到期的定时器数量达到n个, 用于确认被测代码已经开始等待, 然后再推进时间
+func (f *Fake) BlockUntil(n int) {
+	for {
+		f.mx.Lock()
+		if len(f.waiters) >= n {
+			f.mx.Unlock()
+			return
+		}
+		changed := f.changed
+		f.mx.Unlock()
+		<-changed
+	}
+}
+
+func (f *Fake) addLocked(w *fakeWaiter) {
+	i := sort.Search(len(f.waiters), func(i int) bool { return f.waiters[i].deadline.After(w.deadline) })
+	f.waiters = append(f.waiters, nil)
+	copy(f.waiters[i+1:], f.waiters[i:])
+	f.waiters[i] = w
+	f.notifyLocked()
+}
+
+func (f *Fake) removeLocked(w *fakeWaiter) bool {
+	for i, v := range f.waiters {
+		if v == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			f.notifyLocked()
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fake) notifyLocked() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// Fake 的定时器, period大于0时为周期定时器
+type fakeWaiter struct {
+	clock    *Fake
+	ch       chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+// 和标准库一样, 未及时取走的触发会被丢弃
+func (w *fakeWaiter) fire(now time.Time) {
+	select {
+	case w.ch <- now:
+	default:
+	}
+}
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.mx.Lock()
+	defer w.clock.mx.Unlock()
+	return w.clock.removeLocked(w)
+}
+
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	f := w.clock
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	active := f.removeLocked(w)
+	if w.period > 0 {
+		w.period = d
+	}
+	w.deadline = f.now.Add(d)
+	if d <= 0 && w.period == 0 { // 立即触发
+		w.fire(f.now)
+		return active
+	}
+	f.addLocked(w)
+	return active
+}
+
+type fakeTimer struct{ *fakeWaiter }
+
+func (t fakeTimer) C() <-chan time.Time { return t.ch }
+
+type fakeTicker struct{ *fakeWaiter }
+
+func (t fakeTicker) C() <-chan time.Time { return t.ch }
+func (t fakeTicker) Stop()               { t.fakeWaiter.Stop() }
+func (t fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("clock: ticker的周期必须大于0")
+	}
+	t.fakeWaiter.Reset(d)
+}
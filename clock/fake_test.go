@@ -0,0 +1,120 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeTimer(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := NewFake(start)
+	timer := clk.NewTimer(time.Second)
+
+	clk.Advance(time.Millisecond * 999)
+	select {
+	case <-timer.C():
+		t.Fatal("未到期的timer被触发")
+	default:
+	}
+
+	clk.Advance(time.Millisecond)
+	require.Equal(t, start.Add(time.Second), <-timer.C())
+	require.Equal(t, time.Second, clk.Since(start))
+	require.False(t, timer.Stop())
+
+	require.False(t, timer.Reset(time.Second))
+	require.True(t, timer.Stop())
+	clk.Advance(time.Second * 2)
+	select {
+	case <-timer.C():
+		t.Fatal("已停止的timer被触发")
+	default:
+	}
+	require.Equal(t, 0, clk.Waiters())
+}
+
+func TestFakeTicker(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := NewFake(start)
+	ticker := clk.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for i := 1; i <= 3; i++ {
+		clk.Advance(time.Second)
+		require.Equal(t, start.Add(time.Second*time.Duration(i)), <-ticker.C())
+	}
+
+	// 一次推进多个周期时, 未取走的触发会被丢弃
+	clk.Advance(time.Second * 3)
+	require.Equal(t, start.Add(time.Second*4), <-ticker.C())
+
+	ticker.Reset(time.Minute)
+	clk.Advance(time.Second * 59)
+	select {
+	case <-ticker.C():
+		t.Fatal("未到期的ticker被触发")
+	default:
+	}
+	clk.Advance(time.Second)
+	require.Equal(t, start.Add(time.Second*6+time.Minute), <-ticker.C())
+}
+
+// 多个timer按到期时间依次触发, 期间的Now为触发时间
+func TestFakeAdvanceOrder(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := NewFake(start)
+	t2 := clk.NewTimer(time.Second * 2)
+	t1 := clk.NewTimer(time.Second)
+
+	clk.Advance(time.Second * 5)
+	require.Equal(t, start.Add(time.Second), <-t1.C())
+	require.Equal(t, start.Add(time.Second*2), <-t2.C())
+	require.Equal(t, start.Add(time.Second*5), clk.Now())
+
+	clk.Set(start) // 不能回退
+	require.Equal(t, start.Add(time.Second*5), clk.Now())
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	clk := NewFake(time.Time{})
+	done := make(chan struct{})
+	go func() {
+		timer := clk.NewTimer(time.Second)
+		<-timer.C()
+		close(done)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	<-done
+}
+
+func TestWithTimeout(t *testing.T) {
+	clk := NewFake(time.Time{})
+	ctx, cancel := WithTimeout(context.Background(), clk, time.Second)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	require.False(t, ok)
+
+	clk.Advance(time.Millisecond * 999)
+	require.Nil(t, ctx.Err())
+	clk.Advance(time.Millisecond)
+	<-ctx.Done()
+	require.Equal(t, context.DeadlineExceeded, ctx.Err())
+
+	// 取消时同步停止定时器
+	ctx, cancel = WithTimeout(context.Background(), clk, time.Second)
+	require.Equal(t, 1, clk.Waiters())
+	cancel()
+	require.Equal(t, 0, clk.Waiters())
+	require.Equal(t, context.Canceled, ctx.Err())
+
+	// 系统时钟使用 context.WithTimeout
+	ctx, cancel = WithTimeout(context.Background(), Real(), time.Second)
+	defer cancel()
+	_, ok = ctx.Deadline()
+	require.True(t, ok)
+}
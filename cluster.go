@@ -0,0 +1,552 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zlyuancn/connpool/clock"
+)
+
+// 负载均衡策略
+type BalanceStrategy int
+
+const (
+	BalanceRoundRobin  BalanceStrategy = iota // 轮询
+	BalanceLeastActive                        // 最少活跃conn优先
+	BalanceP2C                                // 随机选择两个节点, 取活跃conn较少的
+	BalanceWeighted                           // 平滑加权轮询
+)
+
+func (s BalanceStrategy) String() string {
+	switch s {
+	case BalanceRoundRobin:
+		return "round_robin"
+	case BalanceLeastActive:
+		return "least_active"
+	case BalanceP2C:
+		return "p2c"
+	case BalanceWeighted:
+		return "weighted"
+	}
+	return "unknown"
+}
+
+// 集群节点
+type Endpoint struct {
+	Addr   string // 节点地址, 会传给 ClusterCreator
+	Weight int    // 权重, 只在 BalanceWeighted 策略下生效, 小于1视为1
+}
+
+// 集群创造者, addr为节点地址
+type ClusterCreator func(ctx context.Context, addr string) (interface{}, error)
+
+type ClusterConfig struct {
+	Config                         // 每个节点的连接池配置, 其中的 Creator 由 ClusterConfig.Creator 接管, MaxActive 为单个节点的限制
+	Endpoints      []Endpoint      // 节点列表
+	Strategy       BalanceStrategy // 负载均衡策略
+	TotalMaxActive int             // 所有节点共享的最大活跃连接数, 小于1表示不限制
+	Outlier        OutlierConfig   // 异常节点驱逐配置, 默认不启用
+	Creator        ClusterCreator
+}
+
+func NewClusterConfig() *ClusterConfig {
+	return &ClusterConfig{
+		Config:   *NewConfig(),
+		Strategy: BalanceRoundRobin,
+	}
+}
+
+func (conf *ClusterConfig) check() error {
+	if len(conf.Endpoints) == 0 {
+		return errors.New("未设置 Endpoints")
+	}
+	addrs := make(map[string]struct{}, len(conf.Endpoints))
+	for _, ep := range conf.Endpoints {
+		if _, ok := addrs[ep.Addr]; ok {
+			return fmt.Errorf("重复的节点地址: %s", ep.Addr)
+		}
+		addrs[ep.Addr] = struct{}{}
+	}
+	if conf.WaitTimeout < 1 {
+		conf.WaitTimeout = defWaitTimeout
+	}
+	if conf.Clock == nil {
+		conf.Clock = clock.Real()
+	}
+	conf.Outlier.check()
+	if conf.Creator == nil {
+		return errors.New("未设置 Creator")
+	}
+	if conf.ConnClose == nil {
+		return errors.New("未设置 ConnClose")
+	}
+	return nil
+}
+
+// 集群中的一个节点
+type clusterEndpoint struct {
+	addr   string
+	weight int
+	pool   *ConnectPool
+
+	// 以下字段由 ClusterPool.mx 保护
+	active        int          // 通过集群取出未放回的conn数量
+	currentWeight int          // 平滑加权轮询的当前权重
+	outlier       outlierState // 驱逐状态
+}
+
+// 多节点连接池, 每个节点一个子连接池, Get 时按负载均衡策略选择节点, 节点获取失败时自动切换到其它节点
+type ClusterPool struct {
+	conf      *ClusterConfig
+	endpoints []*clusterEndpoint
+	byPool    map[*ConnectPool]*clusterEndpoint // 根据conn所属的子连接池找到节点, 创建后不再修改
+
+	rr         uint64        // 轮询计数, atomic操作
+	activeLock chan struct{} // 所有节点共享的活跃锁, 未限制时为nil
+	mx         sync.Mutex
+	borrowed   map[*Conn]*clusterEndpoint // 通过集群取出未放回的conn
+
+	close chan struct{} // 关闭信号
+}
+
+var _ IConnectPool = (*ClusterPool)(nil)
+
+func NewClusterPool(conf *ClusterConfig) (*ClusterPool, error) {
+	if err := conf.check(); err != nil {
+		return nil, fmt.Errorf("配置检查失败: %v", err)
+	}
+
+	pool := &ClusterPool{
+		conf:     conf,
+		byPool:   make(map[*ConnectPool]*clusterEndpoint, len(conf.Endpoints)),
+		borrowed: make(map[*Conn]*clusterEndpoint),
+		close:    make(chan struct{}),
+	}
+	if conf.TotalMaxActive > 0 {
+		pool.activeLock = make(chan struct{}, conf.TotalMaxActive)
+		for i := 0; i < conf.TotalMaxActive; i++ {
+			pool.activeLock <- struct{}{}
+		}
+	}
+
+	for _, ep := range conf.Endpoints {
+		e := &clusterEndpoint{addr: ep.Addr, weight: ep.Weight}
+		if e.weight < 1 {
+			e.weight = 1
+		}
+
+		p, err := NewConnectPool(pool.makeEndpointConfig(e))
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("创建节点 %s 的连接池失败: %v", e.addr, err)
+		}
+		// 已创建的节点在后台创建conn时会读取节点列表, 需要加锁
+		pool.mx.Lock()
+		e.pool = p.(*ConnectPool)
+		pool.endpoints = append(pool.endpoints, e)
+		pool.byPool[e.pool] = e
+		pool.mx.Unlock()
+	}
+	return pool, nil
+}
+
+// 生成节点的连接池配置, Creator, HealthChecker 和 ValidConnected 的结果会被用于异常节点驱逐
+func (c *ClusterPool) makeEndpointConfig(ep *clusterEndpoint) *Config {
+	conf := c.conf.Config
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		v, err := c.conf.Creator(ctx, ep.addr)
+		c.recordResult(ep, err == nil)
+		return v, err
+	}
+	if checker := conf.HealthChecker; checker != nil {
+		conf.HealthChecker = HealthCheckFunc(func(ctx context.Context, conn *Conn) error {
+			err := checker.Check(ctx, conn)
+			c.recordResult(ep, err == nil)
+			return err
+		})
+	}
+	if valid := conf.ValidConnected; valid != nil {
+		conf.ValidConnected = func(conn *Conn) bool {
+			ok := valid(conn)
+			if !ok { // 每次取出和放回都会校验, 只记录失败
+				c.recordResult(ep, false)
+			}
+			return ok
+		}
+	}
+	conf.onReclaim = c.reclaimed
+	return &conf
+}
+
+// 节点回收了泄漏的conn, 释放其在集群中占用的活跃计数
+func (c *ClusterPool) reclaimed(conn *Conn) {
+	c.mx.Lock()
+	ep, ok := c.borrowed[conn]
+	if ok {
+		delete(c.borrowed, conn)
+		ep.active--
+	}
+	c.mx.Unlock()
+
+	if ok {
+		c.putActiveLock()
+	}
+}
+
+// 获取conn, 选中的节点获取失败时会依次尝试其它节点, 每个节点最多尝试一次
+//
+// 节点需要等待 WaitTimeout 才会失败, 开启 Breaker 后不可用的节点会立即失败从而快速切换
+func (c *ClusterPool) Get(ctx context.Context) (*Conn, error) {
+	if c.isClose() {
+		return nil, ErrPoolClosed
+	}
+	if err := c.acquireActiveLock(ctx); err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	tried := make(map[*clusterEndpoint]struct{}, len(c.endpoints))
+	for len(tried) < len(c.endpoints) {
+		ep := c.pick(tried)
+		tried[ep] = struct{}{}
+
+		conn, err := ep.pool.Get(ctx)
+		if err == nil {
+			c.mx.Lock()
+			c.borrowed[conn] = ep
+			ep.active++
+			c.mx.Unlock()
+			return conn, nil
+		}
+
+		lastErr = fmt.Errorf("节点 %s: %w", ep.addr, err)
+		if ctx.Err() != nil || c.isClose() {
+			break
+		}
+	}
+
+	c.putActiveLock()
+	return nil, lastErr
+}
+
+// 放回conn, 会放回到conn所属的节点
+func (c *ClusterPool) Put(conn *Conn) error {
+	ep, err := c.release(conn)
+	if err != nil {
+		return err
+	}
+	return ep.pool.Put(conn)
+}
+
+// 丢弃已损坏的conn, 参考 IConnectPool
+func (c *ClusterPool) Discard(conn *Conn, reason error) error {
+	ep, err := c.release(conn)
+	if err != nil {
+		return err
+	}
+	return ep.pool.Discard(conn, reason)
+}
+
+// 释放conn在集群中占用的活跃计数, 返回conn所属的节点
+func (c *ClusterPool) release(conn *Conn) (*clusterEndpoint, error) {
+	if conn == nil {
+		return nil, ErrConnNotOwned
+	}
+	ep, ok := c.byPool[conn.pool]
+	if !ok {
+		return nil, ErrConnNotOwned
+	}
+
+	c.mx.Lock()
+	_, ok = c.borrowed[conn]
+	if ok {
+		delete(c.borrowed, conn)
+		ep.active--
+	}
+	c.mx.Unlock()
+
+	// 重复放回的conn交给节点的连接池返回错误
+	if ok {
+		c.putActiveLock()
+	}
+	return ep, nil
+}
+
+func (c *ClusterPool) Close() {
+	if !c.markClose() {
+		return
+	}
+	for _, ep := range c.endpoints {
+		ep.pool.Close()
+	}
+}
+
+// 优雅关闭所有节点, 报告为所有节点的汇总, 返回第一个遇到的错误
+func (c *ClusterPool) Shutdown(ctx context.Context) (ShutdownReport, error) {
+	if !c.markClose() {
+		return ShutdownReport{}, ErrPoolClosed
+	}
+
+	var report ShutdownReport
+	var firstErr error
+	var mx sync.Mutex
+	var wg sync.WaitGroup
+	for _, ep := range c.endpoints {
+		wg.Add(1)
+		go func(ep *clusterEndpoint) {
+			defer wg.Done()
+			r, err := ep.pool.Shutdown(ctx)
+			mx.Lock()
+			report.ClosedIdle += r.ClosedIdle
+			report.Leaked += r.Leaked
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("节点 %s: %w", ep.addr, err)
+			}
+			mx.Unlock()
+		}(ep)
+	}
+	wg.Wait()
+	return report, firstErr
+}
+
+// 获取所有节点的汇总统计快照
+//
+// BreakerState 在所有节点熔断时为 BreakerOpen, 部分节点熔断时为 BreakerHalfOpen,
+// MaxActive 为所有节点之和, 设置了更小的 TotalMaxActive 时为 TotalMaxActive
+func (c *ClusterPool) Stats() Stats {
+	st := Stats{CloseCount: make(map[CloseReason]int64, closeReasonCount)}
+	open := 0
+	unlimited := false
+	for _, ep := range c.endpoints {
+		s := ep.pool.Stats()
+		st.MinIdle += s.MinIdle
+		st.MaxIdle += s.MaxIdle
+		st.MaxActive += s.MaxActive
+		if s.MaxActive < 1 {
+			unlimited = true
+		}
+		st.merge(s)
+		if s.BreakerState != BreakerClosed {
+			open++
+			st.BreakerState = BreakerHalfOpen
+		}
+	}
+	if open == len(c.endpoints) {
+		st.BreakerState = BreakerOpen
+	}
+	if unlimited {
+		st.MaxActive = 0
+	}
+	if c.conf.TotalMaxActive > 0 && (st.MaxActive < 1 || c.conf.TotalMaxActive < st.MaxActive) {
+		st.MaxActive = c.conf.TotalMaxActive
+	}
+	return st
+}
+
+// 预热所有节点, 每个节点的空闲conn都会达到n个, 返回所有节点汇总的 *WarmupError
+func (c *ClusterPool) Warmup(ctx context.Context, n int) error {
+	var mx sync.Mutex
+	var wg sync.WaitGroup
+	warmupErr := &WarmupError{}
+	for _, ep := range c.endpoints {
+		wg.Add(1)
+		go func(ep *clusterEndpoint) {
+			defer wg.Done()
+			err := ep.pool.Warmup(ctx, n)
+			if err == nil {
+				return
+			}
+
+			mx.Lock()
+			defer mx.Unlock()
+			var e *WarmupError
+			if errors.As(err, &e) {
+				warmupErr.Created += e.Created
+				for _, err := range e.Errs {
+					warmupErr.Errs = append(warmupErr.Errs, fmt.Errorf("节点 %s: %w", ep.addr, err))
+				}
+				return
+			}
+			warmupErr.Errs = append(warmupErr.Errs, fmt.Errorf("节点 %s: %w", ep.addr, err))
+		}(ep)
+	}
+	wg.Wait()
+
+	if len(warmupErr.Errs) > 0 {
+		return warmupErr
+	}
+	return nil
+}
+
+// 每个节点主动释放最多n个空闲conn, 返回释放的总数
+func (c *ClusterPool) Shrink(n int) int {
+	shrink := 0
+	for _, ep := range c.endpoints {
+		shrink += ep.pool.Shrink(n)
+	}
+	return shrink
+}
+
+// 修改所有节点的连接池配置, 参考 ConnectPool.UpdateConfig, 返回第一个遇到的错误
+func (c *ClusterPool) UpdateConfig(update func(conf *Config)) error {
+	for _, ep := range c.endpoints {
+		if err := ep.pool.UpdateConfig(update); err != nil {
+			return fmt.Errorf("节点 %s: %w", ep.addr, err)
+		}
+	}
+	return nil
+}
+
+// 获取每个节点的统计快照, key为节点地址
+func (c *ClusterPool) EndpointStats() map[string]Stats {
+	m := make(map[string]Stats, len(c.endpoints))
+	for _, ep := range c.endpoints {
+		m[ep.addr] = ep.pool.Stats()
+	}
+	return m
+}
+
+// 获取conn所属的节点地址, conn不属于该集群时返回空字符串
+func (c *ClusterPool) AddrOf(conn *Conn) string {
+	if conn == nil {
+		return ""
+	}
+	if ep, ok := c.byPool[conn.pool]; ok {
+		return ep.addr
+	}
+	return ""
+}
+
+// 按负载均衡策略选择一个未尝试过的节点, 调用方需保证至少还有一个未尝试的节点
+//
+// 被驱逐的节点只有在其它节点都已尝试过时才会被选择
+func (c *ClusterPool) pick(tried map[*clusterEndpoint]struct{}) *clusterEndpoint {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.checkEjected()
+
+	candidates := make([]*clusterEndpoint, 0, len(c.endpoints))
+	var ejected []*clusterEndpoint
+	offset := int(atomic.AddUint64(&c.rr, 1) - 1)
+	for i := range c.endpoints {
+		ep := c.endpoints[(offset+i)%len(c.endpoints)]
+		if _, ok := tried[ep]; ok {
+			continue
+		}
+		if ep.outlier.ejected {
+			ejected = append(ejected, ep)
+			continue
+		}
+		candidates = append(candidates, ep)
+	}
+	if len(candidates) == 0 {
+		candidates = ejected
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	switch c.conf.Strategy {
+	case BalanceLeastActive:
+		best := candidates[0]
+		for _, ep := range candidates[1:] {
+			if ep.active < best.active {
+				best = ep
+			}
+		}
+		return best
+	case BalanceP2C:
+		i := rand.Intn(len(candidates))
+		j := rand.Intn(len(candidates) - 1)
+		if j >= i {
+			j++
+		}
+		a, b := candidates[i], candidates[j]
+		if b.active < a.active {
+			return b
+		}
+		return a
+	case BalanceWeighted:
+		var best *clusterEndpoint
+		total := 0
+		for _, ep := range c.endpoints { // 按节点顺序遍历, 保证结果稳定
+			if !inCandidates(candidates, ep) {
+				continue
+			}
+			ep.currentWeight += ep.weight
+			total += ep.weight
+			if best == nil || ep.currentWeight > best.currentWeight {
+				best = ep
+			}
+		}
+		best.currentWeight -= total
+		return best
+	}
+	return candidates[0] // BalanceRoundRobin
+}
+
+func inCandidates(candidates []*clusterEndpoint, ep *clusterEndpoint) bool {
+	for _, e := range candidates {
+		if e == ep {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取共享的活跃锁
+func (c *ClusterPool) acquireActiveLock(ctx context.Context) error {
+	if c.activeLock == nil {
+		return nil
+	}
+
+	select {
+	case <-c.activeLock:
+		return nil
+	default:
+	}
+
+	t := c.conf.Clock.NewTimer(c.conf.WaitTimeout)
+	defer t.Stop()
+	select {
+	case <-c.activeLock:
+		return nil
+	case <-c.close:
+		return ErrPoolClosed
+	case <-ctx.Done():
+		return ErrWaitGetConnTimeout
+	case <-t.C():
+		return ErrWaitGetConnTimeout
+	}
+}
+
+// 放回共享的活跃锁
+func (c *ClusterPool) putActiveLock() {
+	if c.activeLock != nil {
+		c.activeLock <- struct{}{}
+	}
+}
+
+// 标记为已关闭, 如果之前已关闭返回false
+func (c *ClusterPool) markClose() bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.isClose() {
+		return false
+	}
+	close(c.close)
+	return true
+}
+
+func (c *ClusterPool) isClose() bool {
+	select {
+	case <-c.close:
+		return true
+	default:
+		return false
+	}
+}
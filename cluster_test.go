@@ -0,0 +1,209 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zlyuancn/connpool/clock"
+)
+
+type testAddrConn struct{ addr string }
+
+func makeTestClusterConfig(addrs ...string) *ClusterConfig {
+	conf := NewClusterConfig()
+	conf.MinIdle = 1
+	conf.ConnClose = testConnClose
+	conf.Creator = func(ctx context.Context, addr string) (interface{}, error) {
+		return testAddrConn{addr}, nil
+	}
+	for _, addr := range addrs {
+		conf.Endpoints = append(conf.Endpoints, Endpoint{Addr: addr})
+	}
+	return conf
+}
+
+// 取出n个conn并统计每个节点的数量
+func getClusterConns(t *testing.T, p *ClusterPool, n int) ([]*Conn, map[string]int) {
+	conns := make([]*Conn, 0, n)
+	count := make(map[string]int)
+	for i := 0; i < n; i++ {
+		conn, err := p.Get(context.Background())
+		require.Nil(t, err)
+		require.Equal(t, p.AddrOf(conn), conn.GetConn().(testAddrConn).addr)
+		conns = append(conns, conn)
+		count[p.AddrOf(conn)]++
+	}
+	return conns, count
+}
+
+func TestClusterRoundRobin(t *testing.T) {
+	conf := makeTestClusterConfig("a", "b", "c")
+	p, err := NewClusterPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conns, count := getClusterConns(t, p, 6)
+	require.Equal(t, map[string]int{"a": 2, "b": 2, "c": 2}, count)
+	for _, conn := range conns {
+		require.Nil(t, p.Put(conn))
+	}
+	require.Equal(t, 0, p.Stats().ActiveCount)
+}
+
+func TestClusterWeighted(t *testing.T) {
+	conf := makeTestClusterConfig()
+	conf.Strategy = BalanceWeighted
+	conf.Endpoints = []Endpoint{{Addr: "a", Weight: 3}, {Addr: "b", Weight: 1}}
+	p, err := NewClusterPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	_, count := getClusterConns(t, p, 8)
+	require.Equal(t, map[string]int{"a": 6, "b": 2}, count)
+}
+
+func TestClusterLeastActive(t *testing.T) {
+	conf := makeTestClusterConfig("a", "b")
+	conf.Strategy = BalanceLeastActive
+	p, err := NewClusterPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conns, _ := getClusterConns(t, p, 4)
+	// 放回b的所有conn, 之后的请求应该全部落在b上
+	for _, conn := range conns {
+		if p.AddrOf(conn) == "b" {
+			require.Nil(t, p.Put(conn))
+		}
+	}
+	_, count := getClusterConns(t, p, 2)
+	require.Equal(t, map[string]int{"b": 2}, count)
+}
+
+func TestClusterP2C(t *testing.T) {
+	conf := makeTestClusterConfig("a", "b")
+	conf.Strategy = BalanceP2C
+	p, err := NewClusterPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	// 只有两个节点时每次都会比较这两个节点, 持有conn时会均匀分布
+	_, count := getClusterConns(t, p, 6)
+	require.Equal(t, map[string]int{"a": 3, "b": 3}, count)
+}
+
+// 节点不可用时切换到其它节点
+func TestClusterFailover(t *testing.T) {
+	conf := makeTestClusterConfig("bad", "good")
+	conf.WaitTimeout = time.Millisecond * 200
+	conf.Breaker.FailureThreshold = 1
+	conf.Creator = func(ctx context.Context, addr string) (interface{}, error) {
+		if addr == "bad" {
+			return nil, errors.New("connection refused")
+		}
+		return testAddrConn{addr}, nil
+	}
+	p, err := NewClusterPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	_, count := getClusterConns(t, p, 4)
+	require.Equal(t, map[string]int{"good": 4}, count)
+}
+
+// 所有节点共享最大活跃连接数
+func TestClusterTotalMaxActive(t *testing.T) {
+	conf := makeTestClusterConfig("a", "b")
+	conf.TotalMaxActive = 2
+	conf.WaitTimeout = time.Millisecond * 200
+	p, err := NewClusterPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conns, _ := getClusterConns(t, p, 2)
+	_, err = p.Get(context.Background())
+	require.Equal(t, ErrWaitGetConnTimeout, err)
+
+	// 重复放回不会多释放活跃锁
+	require.Nil(t, p.Put(conns[0]))
+	require.Equal(t, ErrConnNotBorrowed, p.Put(conns[0]))
+	_, err = p.Get(context.Background())
+	require.Nil(t, err)
+	_, err = p.Get(context.Background())
+	require.Equal(t, ErrWaitGetConnTimeout, err)
+
+	require.Nil(t, p.Discard(conns[1], errors.New("broken pipe")))
+	_, err = p.Get(context.Background())
+	require.Nil(t, err)
+}
+
+func TestClusterForeignConn(t *testing.T) {
+	p, err := NewClusterPool(makeTestClusterConfig("a"))
+	require.Nil(t, err)
+	defer p.Close()
+
+	other, err := NewConnectPool(makeTestConfig())
+	require.Nil(t, err)
+	defer other.Close()
+	conn, err := other.Get(context.Background())
+	require.Nil(t, err)
+
+	require.Equal(t, ErrConnNotOwned, p.Put(conn))
+	require.Equal(t, ErrConnNotOwned, p.Put(nil))
+	require.Equal(t, "", p.AddrOf(conn))
+}
+
+func TestClusterClose(t *testing.T) {
+	p, err := NewClusterPool(makeTestClusterConfig("a", "b"))
+	require.Nil(t, err)
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		_ = p.Put(conn)
+	}()
+	report, err := p.Shutdown(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 0, report.Leaked)
+
+	_, err = p.Get(context.Background())
+	require.Equal(t, ErrPoolClosed, err)
+	p.Close()
+}
+
+func TestClusterConfigCheck(t *testing.T) {
+	_, err := NewClusterPool(makeTestClusterConfig())
+	require.NotNil(t, err)
+	_, err = NewClusterPool(makeTestClusterConfig("a", "a"))
+	require.NotNil(t, err)
+}
+
+// 节点回收泄漏的conn后释放共享的活跃锁
+func TestClusterLeakReclaim(t *testing.T) {
+	fake := clock.NewFake(time.Time{})
+	conf := makeTestClusterConfig("a")
+	conf.Clock = fake
+	conf.TotalMaxActive = 1
+	conf.CheckIdleInterval = time.Second
+	leaks := make(chan LeakInfo, 1)
+	conf.LeakDetection = LeakDetectionConfig{Threshold: time.Second, Reclaim: true, OnLeak: func(info LeakInfo) { leaks <- info }}
+	p, err := NewClusterPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	leaked, err := p.Get(context.Background())
+	require.Nil(t, err)
+
+	fake.Advance(time.Second) // 触发节点的泄漏检查
+	require.True(t, (<-leaks).Reclaimed)
+
+	// 回收时已释放活跃锁, 不需要等待
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	require.Nil(t, p.Put(conn))
+	require.Equal(t, ErrConnClosed, p.Put(leaked))
+}
@@ -3,6 +3,8 @@ package connpool
 import (
 	"errors"
 	"time"
+
+	"github.com/zlyuancn/connpool/clock"
 )
 
 const (
@@ -31,6 +33,8 @@ const (
 
 	// 检查空闲间隔, 包含最小空闲数, 最大空闲数, 空闲链接超时
 	defCheckIdleInterval = time.Second * 5
+	// 等待中的请求每经过多久优先级+1
+	defPriorityAging = time.Second
 )
 
 type Config struct {
@@ -46,9 +50,20 @@ type Config struct {
 	ConnectTimeout    time.Duration // 连接超时
 	MaxConnLifetime   time.Duration // 一个连接最大存活时间, 小于1表示不限制
 	CheckIdleInterval time.Duration // 检查空闲间隔
+	PriorityAging     time.Duration // 等待中的请求每经过多久优先级+1, 防止低优先级的请求饿死, 小于1表示不启用, 参考 WithPriority
+	IdleStrategy      IdleStrategy  // 空闲conn的选择策略, 默认后进先出
+	Clock             clock.Clock   // 时钟, 默认为系统时钟, 测试时可以使用 clock.NewFake 手动推进时间
+	Retry             RetryPolicy   // 创建conn失败后的重试策略
+	Breaker           BreakerConfig // 创建conn的熔断器配置, 默认不启用
 	Creator
 	ConnClose
 	ValidConnected
+	Observer      Observer            // 观察者, 用于观察conn的生命周期事件
+	HealthChecker HealthChecker       // 健康检查器, 比 ValidConnected 更丰富, 支持ctx和超时
+	HealthCheck   HealthCheckConfig   // 健康检查策略
+	LeakDetection LeakDetectionConfig // 泄漏检测配置, 默认不启用
+
+	onReclaim func(conn *Conn) // 泄漏的conn被回收后的回调, 用于 ClusterPool 等上层连接池释放其记录
 }
 
 func NewConfig() *Config {
@@ -65,9 +80,13 @@ func NewConfig() *Config {
 		ConnectTimeout:    defConnectTimeout,
 		MaxConnLifetime:   defMaxConnLifetime,
 		CheckIdleInterval: defCheckIdleInterval,
+		PriorityAging:     defPriorityAging,
+		Retry:             newRetryPolicy(),
 		Creator:           nil,
 		ConnClose:         nil,
 		ValidConnected:    nil,
+		Observer:          nil,
+		HealthChecker:     nil,
 	}
 }
 
@@ -97,7 +116,7 @@ func (conf *Config) Check() error {
 		conf.WaitTimeout = defWaitTimeout
 	}
 	if conf.MaxWaitConnCount < 1 {
-		conf.BatchShrink = defBatchShrink
+		conf.MaxWaitConnCount = defMaxWaitConnCount
 	}
 	if conf.ConnectTimeout < 1 {
 		conf.ConnectTimeout = defConnectTimeout
@@ -108,6 +127,12 @@ func (conf *Config) Check() error {
 	if conf.CheckIdleInterval < 1 {
 		conf.CheckIdleInterval = defCheckIdleInterval
 	}
+	if conf.Clock == nil {
+		conf.Clock = clock.Real()
+	}
+	conf.Retry.check()
+	conf.Breaker.check()
+	conf.HealthCheck.check(conf)
 	if conf.Creator == nil {
 		return errors.New("未设置 Creator")
 	}
@@ -1,13 +1,36 @@
 package connpool
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// conn的状态
+type connState int32
+
+const (
+	connStateIdle     connState = iota // 在连接池中
+	connStateBorrowed                  // 已被取出
+	connStateClosed                    // 已被移除
+)
+
+// conn的id生成器
+var connIDSeq uint64
+
 type Conn struct {
-	v          interface{} // 通过 Creator 创建的真实连接
-	createTime int64       // 创建时间, 秒级时间戳
-	putTimeSec int64       // 放入时间, 秒级时间戳
+	v           interface{}  // 通过 Creator 创建的真实连接
+	id          uint64       // conn的唯一id
+	pool        *ConnectPool // 所属连接池
+	state       int32        // conn的状态 connState, atomic操作
+	createTime  int64        // 创建时间, 纳秒级时间戳
+	putTime     int64        // 放入时间, 纳秒级时间戳
+	checkTime   int64        // 最后一次健康检查成功的时间, 纳秒级时间戳
+	lastUseTime int64        // 最后一次被取出或放回的时间, 纳秒级时间戳, atomic操作
+	useCount    int64        // 被取出的次数, atomic操作
+
+	attrMx sync.RWMutex
+	attrs  map[string]interface{} // 用户属性
 }
 
 // 获取通过 Creator 创建的真实连接
@@ -15,32 +38,151 @@ func (c *Conn) GetConn() interface{} {
 	return c.v
 }
 
+// 获取conn的唯一id, 进程内从1开始递增
+func (c *Conn) ID() uint64 {
+	return c.id
+}
+
+// 获取conn的创建时间
+func (c *Conn) CreatedAt() time.Time {
+	return time.Unix(0, c.createTime)
+}
+
+// 获取conn的存活时间, 由所属连接池的 Clock 计算
+func (c *Conn) Age() time.Duration {
+	if c.pool == nil {
+		return time.Since(c.CreatedAt())
+	}
+	return c.pool.since(c.CreatedAt())
+}
+
+// 获取conn最后一次被取出或放回的时间, 从未被取出时为创建时间
+func (c *Conn) LastUsedAt() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastUseTime))
+}
+
+// 获取conn被取出的次数
+func (c *Conn) UseCount() int64 {
+	return atomic.LoadInt64(&c.useCount)
+}
+
+// 设置用户属性, 如conn连接的节点地址, 协商的协议版本等
+func (c *Conn) SetAttr(key string, value interface{}) {
+	c.attrMx.Lock()
+	if c.attrs == nil {
+		c.attrs = make(map[string]interface{})
+	}
+	c.attrs[key] = value
+	c.attrMx.Unlock()
+}
+
+// 获取用户属性
+func (c *Conn) GetAttr(key string) (interface{}, bool) {
+	c.attrMx.RLock()
+	v, ok := c.attrs[key]
+	c.attrMx.RUnlock()
+	return v, ok
+}
+
+// 删除用户属性
+func (c *Conn) DelAttr(key string) {
+	c.attrMx.Lock()
+	delete(c.attrs, key)
+	c.attrMx.Unlock()
+}
+
 // 传入一个真实连接以生成conn
-func makeConn(v interface{}) *Conn {
+func makeConn(pool *ConnectPool, v interface{}) *Conn {
+	now := pool.now().UnixNano()
 	return &Conn{
-		v:          v,
-		createTime: time.Now().Unix(),
+		v:           v,
+		id:          atomic.AddUint64(&connIDSeq, 1),
+		pool:        pool,
+		createTime:  now,
+		lastUseTime: now,
 	}
 }
 
+// 传入一个真实连接以生成不属于任何连接池的conn, 用于在测试中实现 IConnectPool 的替身.
+// 这样的conn放回 ConnectPool 时会返回 ErrConnNotOwned
+func NewConn(v interface{}) *Conn {
+	now := time.Now().UnixNano()
+	return &Conn{
+		v:           v,
+		id:          atomic.AddUint64(&connIDSeq, 1),
+		createTime:  now,
+		lastUseTime: now,
+	}
+}
+
+// 标记conn被取出, 返回是否为第一次被取出
+func (c *Conn) markBorrowed() bool {
+	c.setState(connStateBorrowed)
+	atomic.StoreInt64(&c.lastUseTime, c.pool.now().UnixNano())
+	return atomic.AddInt64(&c.useCount, 1) == 1
+}
+
+func (c *Conn) getState() connState {
+	return connState(atomic.LoadInt32(&c.state))
+}
+
+func (c *Conn) setState(state connState) {
+	atomic.StoreInt32(&c.state, int32(state))
+}
+
+func (c *Conn) casState(old, new connState) bool {
+	return atomic.CompareAndSwapInt32(&c.state, int32(old), int32(new))
+}
+
+// 检查conn是否为该连接池取出且未放回的conn
+func (c *ConnectPool) checkBorrowedConn(conn *Conn) error {
+	if conn == nil || conn.pool != c {
+		return ErrConnNotOwned
+	}
+
+	switch conn.getState() {
+	case connStateIdle:
+		return ErrConnNotBorrowed
+	case connStateClosed:
+		return ErrConnClosed
+	}
+	return nil
+}
+
+// 将取出的conn改为state状态, 并发放回或丢弃同一个conn时只有一个调用者能成功
+func (c *ConnectPool) returnBorrowedConn(conn *Conn, state connState) error {
+	if err := c.checkBorrowedConn(conn); err != nil {
+		return err
+	}
+	if !conn.casState(connStateBorrowed, state) {
+		if err := c.checkBorrowedConn(conn); err != nil {
+			return err
+		}
+		return ErrConnNotBorrowed
+	}
+	return nil
+}
+
 // 校验conn
 func (c *ConnectPool) validConn(conn *Conn) bool {
 	// 无效的conn
-	if c.conf.ValidConnected != nil && !c.conf.ValidConnected(conn) {
+	if c.config().ValidConnected != nil && !c.config().ValidConnected(conn) {
+		conn.setState(connStateClosed)
+		go c.closeConn(conn, CloseReasonInvalid) // 可能持有锁, 观察者回调在锁外调用
 		return false
 	}
 
 	// 最大存活时间超时
-	if c.conf.MaxConnLifetime > 0 &&
-		time.Duration(time.Now().Unix()-conn.createTime)*time.Second >= c.conf.MaxConnLifetime {
-		go c.CloseConn(conn)
+	if c.config().MaxConnLifetime > 0 &&
+		time.Duration(c.now().UnixNano()-conn.createTime) >= c.config().MaxConnLifetime {
+		go c.closeConn(conn, CloseReasonLifetime)
 		return false
 	}
 
 	// 空闲超时
-	if c.conf.IdleTimeout > 1 && conn.putTimeSec > 0 &&
-		time.Duration(time.Now().Unix()-conn.putTimeSec)*time.Second >= c.conf.IdleTimeout {
-		go c.CloseConn(conn)
+	if c.config().IdleTimeout > 1 && conn.putTime > 0 &&
+		time.Duration(c.now().UnixNano()-conn.putTime) >= c.config().IdleTimeout {
+		go c.closeConn(conn, CloseReasonIdleTimeout)
 		return false
 	}
 
@@ -49,21 +191,41 @@ func (c *ConnectPool) validConn(conn *Conn) bool {
 
 // 关闭conn
 func (c *ConnectPool) CloseConn(conn *Conn) {
-	if c.conf.ConnClose == nil {
+	if c.config().ConnClose == nil {
 		return
 	}
 
-	c.conf.ConnClose(conn)
+	c.config().ConnClose(conn)
+}
+
+// 以指定原因移除conn并记录, 校验失败的conn直接丢弃, 其它原因会关闭conn
+func (c *ConnectPool) closeConn(conn *Conn, reason CloseReason) {
+	conn.setState(connStateClosed)
+	c.stats.addClose(reason)
+	c.obs.OnEvict(conn, reason)
+	if reason == CloseReasonInvalid {
+		return
+	}
+
+	c.CloseConn(conn)
+	c.obs.OnClose(conn, reason)
 }
 
 // 从已连接的conn列表弹出第一个有效的conn, 不存在时返回nil
 func (c *ConnectPool) popFrontConn() *Conn {
+	conn, _ := c.popFrontConnWithIdle()
+	return conn
+}
+
+// 从已连接的conn列表弹出第一个有效的conn, 同时返回其放入时间, 不存在时返回nil
+func (c *ConnectPool) popFrontConnWithIdle() (*Conn, int64) {
 	for c.connList.Len() > 0 {
 		conn := c.connList.Remove(c.connList.Front()).(*Conn)
 		if c.validConn(conn) {
-			conn.putTimeSec = 0 // 重置放入时间
-			return conn
+			idleSince := conn.putTime
+			conn.putTime = 0 // 重置放入时间
+			return conn, idleSince
 		}
 	}
-	return nil
+	return nil, 0
 }
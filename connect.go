@@ -3,22 +3,15 @@ package connpool
 import (
 	"context"
 	"fmt"
-	"time"
+
+	"github.com/zlyuancn/connpool/clock"
 )
 
 // 初始化连接
 func (c *ConnectPool) initConnect() error {
-	// 放入活跃锁
-	if c.conf.MaxActive > 0 {
-		c.activeLock = make(chan struct{}, c.conf.MaxActive)
-		for i := 0; i < c.conf.MaxActive; i++ {
-			c.activeLock <- struct{}{}
-		}
-	}
-
 	// 等待第一个conn
-	if c.conf.WaitFirstConn {
-		err := c.applyConnectLoop()
+	if c.config().WaitFirstConn {
+		err := c.applyConnectRetry()
 		if err != nil {
 			return fmt.Errorf("等待第一个conn失败: %v", err)
 		}
@@ -29,17 +22,20 @@ func (c *ConnectPool) initConnect() error {
 	return nil
 }
 
-// 检查空闲
-func (c *ConnectPool) checkIdleLoop() {
-	t := time.NewTicker(c.conf.CheckIdleInterval)
+// 检查空闲, ticker在启动前创建, 保证创建连接池后已经开始计时
+func (c *ConnectPool) checkIdleLoop(t clock.Ticker) {
 	for {
 		select {
 		case <-c.close:
 			t.Stop()
 			return
-		case <-t.C:
+		case <-c.interval:
+			t.Reset(c.config().CheckIdleInterval)
+		case <-t.C():
 			// 先释放, 再申请, 那么在缺conn的情况下就不会释放正常的conn
+			c.checkLeak()
 			c.releaseInvalidConn()
+			c.checkIdleHealth()
 			c.releaseNeedlessConn()
 			c.replenishLackConn()
 		}
@@ -48,36 +44,61 @@ func (c *ConnectPool) checkIdleLoop() {
 
 // 申请一个连接
 func (c *ConnectPool) applyConnectLoop() error {
-	ctx, cancel := context.WithTimeout(c.baseCtx, c.conf.ConnectTimeout)
+	return c.applyConnect(c.baseCtx)
+}
+
+// 申请一个连接, ctx会传给 Creator
+func (c *ConnectPool) applyConnect(ctx context.Context) error {
+	permit, err := c.breaker.allow()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := clock.WithTimeout(ctx, c.config().Clock, c.config().ConnectTimeout)
 	defer cancel()
 
-	var v interface{}
-	var err error
+	var conn *Conn
+	var createErr error
 	done := make(chan struct{})
 
 	// 协程创建
 	go func() {
-		v, err = c.conf.Creator(ctx)
+		start := c.now()
+		var v interface{}
+		v, createErr = c.config().Creator(ctx)
+		if createErr == nil {
+			conn = makeConn(c, v)
+		}
+		c.recordCreateResult(conn, createErr, c.since(start))
+		c.breaker.onResult(permit, createErr) // 超时后才返回时结果已经记录过, 这里会被忽略
+
 		select {
 		case done <- struct{}{}: // 还在等待中, 直接处理
 			return
 		default: // 自行处理
-			if err == nil {
-				c.autoPutConn(makeConn(v)) // 仍然认可
+			if createErr == nil {
+				c.autoPutConn(conn) // 仍然认可
 			}
 		}
 	}()
 
 	select {
 	case <-done:
-		if err == nil {
-			c.autoPutConn(makeConn(v))
+		if createErr == nil {
+			c.autoPutConn(conn)
 		}
-		return err
+		return createErr
 	case <-c.close:
+		c.breaker.release(permit)
 		return ErrPoolClosed
 	case <-ctx.Done():
-		return ctx.Err()
+		err = ctx.Err()
+		if err == context.DeadlineExceeded { // 连接超时视为创建失败
+			c.breaker.onResult(permit, err)
+		} else {
+			c.breaker.release(permit)
+		}
+		return err
 	}
 }
 
@@ -98,11 +119,7 @@ func (c *ConnectPool) replenishLackConn() {
 
 	for i := 0; i < need; i++ {
 		go func() {
-			err := c.applyConnectLoop()
-			if err != nil {
-				// 创建失败时立即重新创建极有可能也会失败, 一般来说创建失败都是网络或者限流引起的, 等一会儿可能就好了
-				time.Sleep(time.Second)
-			}
+			_ = c.applyConnectRetry()
 			c.mx.Lock()
 			c.connectingCount-- // 不管申请连接结果如何都将正在申请数量-1
 			c.mx.Unlock()
@@ -116,11 +133,11 @@ func (c *ConnectPool) checkNeedConnCount() int {
 
 	// 我们认为多余的MinIdle个conn是有必要的, 因为conn可能会超时等异常导致conn被释放, 此时这些"多余"的conn就派上用场了.
 	// 即使 MacActive 限制, 也应该保持MinIdle个conn备用.
-	need := c.conf.MinIdle + wait - c.connList.Len() // 需要的
+	need := c.config().MinIdle + wait - c.connList.Len() // 需要的
 
 	// 如果确实有需要申请的(wait数量>0), 批量申请
-	if wait > 0 && need < c.conf.BatchIncrement {
-		need = c.conf.BatchIncrement
+	if wait > 0 && need < c.config().BatchIncrement {
+		need = c.config().BatchIncrement
 	}
 
 	need -= c.connectingCount // 实际需要的, 排除正在连接的conn
@@ -129,7 +146,7 @@ func (c *ConnectPool) checkNeedConnCount() int {
 
 // 释放无效的conn
 func (c *ConnectPool) releaseInvalidConn() {
-	if c.conf.IdleTimeout < 1 && c.conf.MaxConnLifetime < 1 {
+	if c.config().IdleTimeout < 1 && c.config().MaxConnLifetime < 1 {
 		return
 	}
 
@@ -161,12 +178,12 @@ func (c *ConnectPool) releaseNeedlessConn() {
 
 	shrink := 0
 	// 如果超过最大空闲并且未达到当前允许批次释放数量
-	for c.connList.Len() > c.conf.MaxIdle && shrink < c.conf.BatchShrink {
-		e := c.connList.Back()
+	for c.connList.Len() > c.config().MaxIdle && shrink < c.config().BatchShrink {
+		e := c.evictCandidate()
 		c.connList.Remove(e)
 		shrink++
 
 		conn := e.Value.(*Conn)
-		go c.CloseConn(conn)
+		go c.closeConn(conn, CloseReasonNeedless)
 	}
 }
@@ -0,0 +1,103 @@
+package connpooltest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zlyuancn/connpool"
+)
+
+// 断言默认的最长等待时间, 连接池的部分释放是异步进行的, 断言会在该时间内重试直到成功.
+//
+// 在较慢的环境(如开启 -race 或 CI)中可以调大该值, 也可以在调用断言时通过 timeout 参数单独指定
+var AssertTimeout = time.Second
+
+// 在超时时间内重试check直到返回nil, 超时后以最后一次的错误使测试失败. 未指定timeout时使用 AssertTimeout
+func eventually(t testing.TB, timeout []time.Duration, check func() error) bool {
+	t.Helper()
+	wait := AssertTimeout
+	if len(timeout) > 0 {
+		wait = timeout[0]
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		err := check()
+		if err == nil {
+			return true
+		}
+		if time.Now().After(deadline) {
+			t.Error(err)
+			return false
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+// 断言没有泄漏, 即没有被取出未放回的conn, 没有等待中的请求以及正在创建的conn
+func AssertNoLeaks(t testing.TB, pool connpool.IConnectPool, timeout ...time.Duration) bool {
+	t.Helper()
+	return eventually(t, timeout, func() error {
+		st := pool.Stats()
+		if st.ActiveCount != 0 || st.ConnectingCount != 0 || st.WaitQueueLen != 0 || st.ActiveWaitQueueLen != 0 {
+			return fmt.Errorf("connpooltest: 存在泄漏, active=%d, connecting=%d, wait=%d, activeWait=%d",
+				st.ActiveCount, st.ConnectingCount, st.WaitQueueLen, st.ActiveWaitQueueLen)
+		}
+		return nil
+	})
+}
+
+// 断言后端创建的所有conn都恰好被释放了一次, 一般在连接池关闭后调用
+func AssertAllClosedOnce(t testing.TB, b *Backend, timeout ...time.Duration) bool {
+	t.Helper()
+	return eventually(t, timeout, func() error {
+		for _, c := range b.Conns() {
+			if n := c.ReleasedCount(); n != 1 {
+				return fmt.Errorf("connpooltest: conn %d 被释放了%d次, 期望1次", c.ID, n)
+			}
+		}
+		return nil
+	})
+}
+
+// 断言没有conn被重复释放
+func AssertNoDoubleClose(t testing.TB, b *Backend) bool {
+	t.Helper()
+	for _, c := range b.Conns() {
+		if n := c.ReleasedCount(); n > 1 {
+			t.Errorf("connpooltest: conn %d 被释放了%d次", c.ID, n)
+			return false
+		}
+	}
+	return true
+}
+
+// 断言连接池的计数器和后端一致
+//
+// 连接池的累计创建次数等于后端创建成功的数量, 累计释放次数等于后端释放的次数,
+// 且创建数减去释放数等于空闲和活跃conn的数量. 要求该后端只被这一个连接池使用.
+func AssertCountersBalanced(t testing.TB, pool connpool.IConnectPool, b *Backend, timeout ...time.Duration) bool {
+	t.Helper()
+	return eventually(t, timeout, func() error {
+		st := pool.Stats()
+		var closed int64
+		for _, n := range st.CloseCount {
+			closed += n
+		}
+		var released int64
+		for _, c := range b.Conns() {
+			released += int64(c.ReleasedCount())
+		}
+
+		if st.CreateCount != int64(b.Created()) {
+			return fmt.Errorf("connpooltest: 连接池创建了%d个conn, 后端创建了%d个", st.CreateCount, b.Created())
+		}
+		if closed != released {
+			return fmt.Errorf("connpooltest: 连接池释放了%d个conn, 后端释放了%d次", closed, released)
+		}
+		if live := st.CreateCount - closed; live != int64(st.IdleCount+st.ActiveCount) {
+			return fmt.Errorf("connpooltest: 存活conn数量为%d, 但idle=%d, active=%d", live, st.IdleCount, st.ActiveCount)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,304 @@
+// 用于测试的工具, 提供可编排故障的假后端以及连接池的断言
+package connpooltest
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zlyuancn/connpool"
+)
+
+var (
+	ErrBackendDown = errors.New("connpooltest: 后端不可用")
+	ErrConnBroken  = errors.New("connpooltest: conn已损坏")
+)
+
+// 假后端创建的连接
+type FakeConn struct {
+	ID      int64 // 从1开始递增
+	backend *Backend
+
+	closed  int32 // 被 ConnClose 关闭的次数, atomic操作
+	dropped int32 // 校验失败被连接池直接丢弃的次数, atomic操作
+	broken  int32 // 是否已损坏, atomic操作
+}
+
+// 被关闭的次数
+func (c *FakeConn) ClosedCount() int {
+	return int(atomic.LoadInt32(&c.closed))
+}
+
+// 被释放的次数, 包括被 ConnClose 关闭以及 ValidConnected 校验失败后被连接池直接丢弃
+func (c *FakeConn) ReleasedCount() int {
+	return c.ClosedCount() + int(atomic.LoadInt32(&c.dropped))
+}
+
+// 标记为已损坏, 之后 ValidConnected 和 Check 都会返回失败
+func (c *FakeConn) Break() {
+	atomic.StoreInt32(&c.broken, 1)
+}
+
+func (c *FakeConn) IsBroken() bool {
+	return atomic.LoadInt32(&c.broken) == 1
+}
+
+// 可编排故障的假后端, 提供 Creator, ConnClose, ValidConnected 以及 HealthChecker 的实现
+type Backend struct {
+	mx          sync.Mutex
+	conns       []*FakeConn
+	failNext    int   // 接下来失败的次数
+	failErr     error // 失败时返回的错误
+	down        error // 不为nil时所有创建都失败
+	hangNext    int   // 接下来阻塞的次数
+	ignoreCtx   bool  // 阻塞和延迟时是否忽略ctx
+	release     chan struct{}
+	invalidUses int64                // conn被取出该次数后失效, 小于1表示不失效
+	latency     func() time.Duration // 创建延迟
+
+	createCalls int64 // 调用 Creator 的次数, atomic操作
+	hanging     int64 // 正在阻塞的数量, atomic操作
+}
+
+func NewBackend() *Backend {
+	return &Backend{release: make(chan struct{})}
+}
+
+// 将 Creator, ConnClose, ValidConnected 设置到配置中
+func (b *Backend) Configure(conf *connpool.Config) {
+	conf.Creator = b.Creator
+	conf.ConnClose = b.ConnClose
+	conf.ValidConnected = b.ValidConnected
+}
+
+// 接下来n次创建失败, err为nil时返回 ErrBackendDown
+func (b *Backend) FailNext(n int, err error) {
+	if err == nil {
+		err = ErrBackendDown
+	}
+	b.mx.Lock()
+	b.failNext = n
+	b.failErr = err
+	b.mx.Unlock()
+}
+
+// 后端宕机, 之后所有创建都返回err直到调用 Up, err为nil时返回 ErrBackendDown
+func (b *Backend) Down(err error) {
+	if err == nil {
+		err = ErrBackendDown
+	}
+	b.mx.Lock()
+	b.down = err
+	b.mx.Unlock()
+}
+
+// 后端恢复
+func (b *Backend) Up() {
+	b.mx.Lock()
+	b.down = nil
+	b.mx.Unlock()
+}
+
+// 接下来n次创建会阻塞, 直到ctx结束或调用 Release
+func (b *Backend) HangNext(n int) {
+	b.mx.Lock()
+	b.hangNext = n
+	b.mx.Unlock()
+}
+
+// 阻塞和延迟时是否忽略ctx, 忽略时只有调用 Release 才会结束阻塞, 用于模拟不遵守ctx的 Creator
+func (b *Backend) IgnoreContext(ignore bool) {
+	b.mx.Lock()
+	b.ignoreCtx = ignore
+	b.mx.Unlock()
+}
+
+// 结束所有正在阻塞的创建
+func (b *Backend) Release() {
+	b.mx.Lock()
+	close(b.release)
+	b.release = make(chan struct{})
+	b.mx.Unlock()
+}
+
+// conn被取出n次后失效, ValidConnected 和 Check 会返回失败
+func (b *Backend) InvalidAfterUses(n int64) {
+	b.mx.Lock()
+	b.invalidUses = n
+	b.mx.Unlock()
+}
+
+// 设置创建延迟, 每次创建前调用latency获取延迟, 为nil时没有延迟
+func (b *Backend) SetLatency(latency func() time.Duration) {
+	b.mx.Lock()
+	b.latency = latency
+	b.mx.Unlock()
+}
+
+// 固定延迟
+func FixedLatency(d time.Duration) func() time.Duration {
+	return func() time.Duration { return d }
+}
+
+// [min, max) 范围内的均匀分布延迟
+func UniformLatency(min, max time.Duration) func() time.Duration {
+	return func() time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(rand.Int63n(int64(max-min)))
+	}
+}
+
+// 正态分布延迟, 不会小于0
+func NormalLatency(mean, stddev time.Duration) func() time.Duration {
+	return func() time.Duration {
+		d := time.Duration(rand.NormFloat64()*float64(stddev)) + mean
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+}
+
+// 实现 connpool.Creator, 创建的连接为 *FakeConn
+func (b *Backend) Creator(ctx context.Context) (interface{}, error) {
+	atomic.AddInt64(&b.createCalls, 1)
+
+	b.mx.Lock()
+	hang := b.hangNext > 0
+	if hang {
+		b.hangNext--
+	}
+	ignoreCtx := b.ignoreCtx
+	release := b.release
+	latency := b.latency
+	b.mx.Unlock()
+
+	if hang {
+		atomic.AddInt64(&b.hanging, 1)
+		err := b.wait(ctx, nil, ignoreCtx, release)
+		atomic.AddInt64(&b.hanging, -1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if latency != nil {
+		t := time.NewTimer(latency())
+		err := b.wait(ctx, t.C, ignoreCtx, release)
+		t.Stop()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.down != nil {
+		return nil, b.down
+	}
+	if b.failNext > 0 {
+		b.failNext--
+		return nil, b.failErr
+	}
+
+	conn := &FakeConn{ID: int64(len(b.conns) + 1), backend: b}
+	b.conns = append(b.conns, conn)
+	return conn, nil
+}
+
+// 等待done或release, 不忽略ctx时ctx结束返回其错误
+func (b *Backend) wait(ctx context.Context, done <-chan time.Time, ignoreCtx bool, release chan struct{}) error {
+	ctxDone := ctx.Done()
+	if ignoreCtx {
+		ctxDone = nil
+	}
+	select {
+	case <-done:
+	case <-release:
+	case <-ctxDone:
+		return ctx.Err()
+	}
+	return nil
+}
+
+// 实现 connpool.ConnClose
+func (b *Backend) ConnClose(conn *connpool.Conn) {
+	if c, ok := conn.GetConn().(*FakeConn); ok {
+		atomic.AddInt32(&c.closed, 1)
+	}
+}
+
+// 实现 connpool.ValidConnected, 校验失败的conn会被连接池直接丢弃而不调用 ConnClose, 因此在这里记录
+func (b *Backend) ValidConnected(conn *connpool.Conn) bool {
+	if b.check(conn) == nil {
+		return true
+	}
+	if c, ok := conn.GetConn().(*FakeConn); ok {
+		atomic.AddInt32(&c.dropped, 1)
+	}
+	return false
+}
+
+// 实现 connpool.HealthChecker
+func (b *Backend) Check(ctx context.Context, conn *connpool.Conn) error {
+	return b.check(conn)
+}
+
+func (b *Backend) check(conn *connpool.Conn) error {
+	c, ok := conn.GetConn().(*FakeConn)
+	if !ok || c.IsBroken() {
+		return ErrConnBroken
+	}
+
+	b.mx.Lock()
+	invalidUses := b.invalidUses
+	b.mx.Unlock()
+	if invalidUses > 0 && conn.UseCount() >= invalidUses {
+		return ErrConnBroken
+	}
+	return nil
+}
+
+// 所有创建成功的连接
+func (b *Backend) Conns() []*FakeConn {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return append([]*FakeConn(nil), b.conns...)
+}
+
+// 创建成功的连接数量
+func (b *Backend) Created() int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return len(b.conns)
+}
+
+// 已被释放的连接数量, 重复释放只计一次
+func (b *Backend) Released() int {
+	n := 0
+	for _, c := range b.Conns() {
+		if c.ReleasedCount() > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// 未被释放的连接数量
+func (b *Backend) Live() int {
+	return b.Created() - b.Released()
+}
+
+// 调用 Creator 的次数, 包含失败的调用
+func (b *Backend) CreateCalls() int64 {
+	return atomic.LoadInt64(&b.createCalls)
+}
+
+// 正在阻塞的创建数量
+func (b *Backend) Hanging() int {
+	return int(atomic.LoadInt64(&b.hanging))
+}
@@ -0,0 +1,206 @@
+package connpooltest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zlyuancn/connpool"
+)
+
+func makeTestPool(t *testing.T, b *Backend, update func(conf *connpool.Config)) connpool.IConnectPool {
+	conf := connpool.NewConfig()
+	b.Configure(conf)
+	conf.MinIdle = 1
+	conf.MaxIdle = 2
+	conf.WaitFirstConn = true
+	if update != nil {
+		update(conf)
+	}
+	p, err := connpool.NewConnectPool(conf)
+	require.Nil(t, err)
+	return p
+}
+
+func TestBackendLifecycle(t *testing.T) {
+	b := NewBackend()
+	p := makeTestPool(t, b, nil)
+
+	conns := make([]*connpool.Conn, 3)
+	for i := range conns {
+		conn, err := p.Get(context.Background())
+		require.Nil(t, err)
+		require.IsType(t, &FakeConn{}, conn.GetConn())
+		conns[i] = conn
+	}
+	for _, conn := range conns {
+		p.Put(conn)
+	}
+
+	require.True(t, AssertNoLeaks(t, p))
+	require.True(t, AssertCountersBalanced(t, p, b))
+
+	p.Close()
+	require.True(t, AssertAllClosedOnce(t, b))
+	require.True(t, AssertNoDoubleClose(t, b))
+	require.Equal(t, 0, b.Live())
+}
+
+func TestBackendFailNext(t *testing.T) {
+	b := NewBackend()
+	errTest := errors.New("test")
+	b.FailNext(2, errTest)
+
+	ctx := context.Background()
+	_, err := b.Creator(ctx)
+	require.Equal(t, errTest, err)
+	_, err = b.Creator(ctx)
+	require.Equal(t, errTest, err)
+	_, err = b.Creator(ctx)
+	require.Nil(t, err)
+
+	b.Down(nil)
+	_, err = b.Creator(ctx)
+	require.Equal(t, ErrBackendDown, err)
+	b.Up()
+	_, err = b.Creator(ctx)
+	require.Nil(t, err)
+
+	require.Equal(t, int64(5), b.CreateCalls())
+	require.Equal(t, 2, b.Created())
+}
+
+func TestBackendHang(t *testing.T) {
+	b := NewBackend()
+	b.HangNext(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err := b.Creator(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// 忽略ctx时只有 Release 才能结束阻塞
+	b.HangNext(1)
+	b.IgnoreContext(true)
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Creator(ctx)
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	require.Equal(t, 1, b.Hanging())
+	select {
+	case <-done:
+		t.Fatal("忽略ctx时不应结束阻塞")
+	default:
+	}
+
+	b.Release()
+	require.Nil(t, <-done)
+	require.Equal(t, 0, b.Hanging())
+}
+
+func TestBackendLatency(t *testing.T) {
+	b := NewBackend()
+	b.SetLatency(FixedLatency(time.Millisecond * 30))
+
+	start := time.Now()
+	_, err := b.Creator(context.Background())
+	require.Nil(t, err)
+	require.GreaterOrEqual(t, time.Since(start), time.Millisecond*30)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err = b.Creator(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	uniform := UniformLatency(time.Millisecond, time.Millisecond*3)
+	normal := NormalLatency(time.Millisecond, time.Millisecond*10)
+	for i := 0; i < 100; i++ {
+		d := uniform()
+		require.GreaterOrEqual(t, d, time.Millisecond)
+		require.Less(t, d, time.Millisecond*3)
+		require.GreaterOrEqual(t, normal(), time.Duration(0))
+	}
+}
+
+func TestBackendInvalidAfterUses(t *testing.T) {
+	b := NewBackend()
+	b.InvalidAfterUses(2)
+	p := makeTestPool(t, b, func(conf *connpool.Config) {
+		conf.MaxIdle = 1
+	})
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	first := conn.GetConn().(*FakeConn)
+	p.Put(conn)
+
+	conn, err = p.Get(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, first, conn.GetConn())
+	p.Put(conn) // 已被取出2次, 放回时失效
+
+	conn, err = p.Get(context.Background())
+	require.Nil(t, err)
+	require.NotEqual(t, first, conn.GetConn())
+	require.Equal(t, 1, first.ReleasedCount())
+	require.Equal(t, 0, first.ClosedCount())
+	p.Put(conn)
+
+	require.True(t, AssertCountersBalanced(t, p, b))
+	p.Close()
+	require.True(t, AssertAllClosedOnce(t, b))
+}
+
+func TestBackendBroken(t *testing.T) {
+	b := NewBackend()
+	p := makeTestPool(t, b, nil)
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	fc := conn.GetConn().(*FakeConn)
+	fc.Break()
+	require.Equal(t, ErrConnBroken, b.Check(context.Background(), conn))
+	p.Put(conn)
+
+	require.Equal(t, 1, fc.ReleasedCount())
+	require.True(t, AssertNoLeaks(t, p))
+	p.Close()
+	require.True(t, AssertAllClosedOnce(t, b))
+}
+
+// 用于验证断言失败
+type recordTB struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recordTB) Helper()                                   {}
+func (r *recordTB) Error(args ...interface{})                 { r.failed = true }
+func (r *recordTB) Errorf(format string, args ...interface{}) { r.failed = true }
+
+func TestAssertFail(t *testing.T) {
+	b := NewBackend()
+	p := makeTestPool(t, b, nil)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+
+	r := &recordTB{TB: t}
+	require.False(t, AssertNoLeaks(r, p, time.Millisecond*20))
+	require.True(t, r.failed)
+
+	r = &recordTB{TB: t}
+	require.False(t, AssertAllClosedOnce(r, b, time.Millisecond*20))
+	require.True(t, r.failed)
+
+	b.ConnClose(conn)
+	b.ConnClose(conn)
+	r = &recordTB{TB: t}
+	require.False(t, AssertNoDoubleClose(r, b))
+	require.True(t, r.failed)
+}
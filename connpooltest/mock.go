@@ -0,0 +1,449 @@
+package connpooltest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/zlyuancn/connpool"
+	"github.com/zlyuancn/connpool/clock"
+)
+
+// mock中conn的状态
+type mockConnState int
+
+const (
+	mockConnIdle mockConnState = iota
+	mockConnBorrowed
+	mockConnClosed
+)
+
+// 一次 Get 的记录
+type GetRecord struct {
+	Conn *connpool.Conn // 取到的conn, 失败时为nil
+	Err  error
+}
+
+// 一次 Discard 的记录
+type DiscardRecord struct {
+	Conn   *connpool.Conn
+	Reason error
+}
+
+// 内存中实现的 connpool.IConnectPool, 没有后台goroutine, 用于依赖 IConnectPool 的代码测试其错误处理
+//
+// conn由 Backend 创建, 记录所有 Get, Put 和 Discard, 可以为下一次 Get 排入指定错误,
+// 活跃conn达到 MaxActive 时 Get 会等待, 直到有conn放回, WaitTimeout 到期或ctx结束, 以此模拟连接池饱和.
+type MockPool struct {
+	backend *Backend
+
+	mx       sync.Mutex
+	conf     *connpool.Config
+	conns    map[*connpool.Conn]mockConnState
+	idle     []*connpool.Conn
+	active   int
+	waiting  int
+	closed   bool
+	draining bool
+	changed  chan struct{} // 状态变化的信号, 变化时关闭并重新创建
+
+	getErrs     []error
+	putErrs     []error
+	discardErrs []error
+
+	gets     []GetRecord
+	puts     []*connpool.Conn
+	discards []DiscardRecord
+	stats    connpool.Stats
+}
+
+var _ connpool.IConnectPool = (*MockPool)(nil)
+
+// 创建一个mock连接池, b为nil时使用一个新的 Backend. 配置使用 connpool.NewConfig 的默认值, 可通过 UpdateConfig 修改
+func NewMockPool(b *Backend) *MockPool {
+	if b == nil {
+		b = NewBackend()
+	}
+	conf := connpool.NewConfig()
+	conf.Clock = clock.Real()
+	return &MockPool{
+		backend: b,
+		conf:    conf,
+		conns:   make(map[*connpool.Conn]mockConnState),
+		changed: make(chan struct{}),
+		stats:   connpool.Stats{CloseCount: make(map[connpool.CloseReason]int64)},
+	}
+}
+
+// 获取创建conn的后端
+func (m *MockPool) Backend() *Backend {
+	return m.backend
+}
+
+// 排入错误, 之后的每次 Get 依次返回其中一个错误而不取conn, 如 connpool.ErrWaitGetConnTimeout
+func (m *MockPool) QueueGetErr(errs ...error) {
+	m.mx.Lock()
+	m.getErrs = append(m.getErrs, errs...)
+	m.mx.Unlock()
+}
+
+// 排入错误, 之后的每次 Put 依次返回其中一个错误, conn仍会被正常放回
+func (m *MockPool) QueuePutErr(errs ...error) {
+	m.mx.Lock()
+	m.putErrs = append(m.putErrs, errs...)
+	m.mx.Unlock()
+}
+
+// 排入错误, 之后的每次 Discard 依次返回其中一个错误, conn仍会被正常丢弃
+func (m *MockPool) QueueDiscardErr(errs ...error) {
+	m.mx.Lock()
+	m.discardErrs = append(m.discardErrs, errs...)
+	m.mx.Unlock()
+}
+
+// 所有 Get 的记录
+func (m *MockPool) Gets() []GetRecord {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return append([]GetRecord(nil), m.gets...)
+}
+
+// 所有被 Put 的conn, 包括返回错误的调用
+func (m *MockPool) Puts() []*connpool.Conn {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return append([]*connpool.Conn(nil), m.puts...)
+}
+
+// 所有 Discard 的记录, 包括返回错误的调用
+func (m *MockPool) Discards() []DiscardRecord {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return append([]DiscardRecord(nil), m.discards...)
+}
+
+// 正在等待的 Get 数量
+func (m *MockPool) Waiting() int {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.waiting
+}
+
+func (m *MockPool) Get(ctx context.Context) (*connpool.Conn, error) {
+	conn, err := m.get(ctx)
+	m.mx.Lock()
+	m.gets = append(m.gets, GetRecord{Conn: conn, Err: err})
+	m.mx.Unlock()
+	return conn, err
+}
+
+func (m *MockPool) get(ctx context.Context) (*connpool.Conn, error) {
+	m.mx.Lock()
+	m.stats.GetCount++
+	if len(m.getErrs) > 0 {
+		err := m.getErrs[0]
+		m.getErrs = m.getErrs[1:]
+		m.mx.Unlock()
+		return nil, err
+	}
+	if m.closed || m.draining {
+		m.mx.Unlock()
+		return nil, connpool.ErrPoolClosed
+	}
+
+	if m.conf.MaxActive > 0 && m.active >= m.conf.MaxActive {
+		if err := m.waitLocked(ctx); err != nil {
+			m.mx.Unlock()
+			return nil, err
+		}
+	}
+	m.active++
+
+	if n := len(m.idle); n > 0 {
+		conn := m.idle[n-1]
+		m.idle = m.idle[:n-1]
+		m.conns[conn] = mockConnBorrowed
+		m.mx.Unlock()
+		return conn, nil
+	}
+	m.mx.Unlock()
+
+	v, err := m.backend.Creator(ctx)
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if err != nil {
+		m.stats.CreateFailCount++
+		m.releaseLocked()
+		return nil, err
+	}
+	m.stats.CreateCount++
+	conn := connpool.NewConn(v)
+	m.conns[conn] = mockConnBorrowed
+	return conn, nil
+}
+
+// 等待活跃conn数量低于 MaxActive, 需要加锁调用, 返回时仍持有锁
+func (m *MockPool) waitLocked(ctx context.Context) error {
+	if m.conf.MaxWaitConnCount > 0 && m.waiting >= m.conf.MaxWaitConnCount {
+		return connpool.ErrMaxWaitConnLimit
+	}
+
+	m.waiting++
+	m.stats.WaitCount++
+	start := m.conf.Clock.Now()
+	t := m.conf.Clock.NewTimer(m.conf.WaitTimeout)
+	defer func() {
+		t.Stop()
+		m.waiting--
+		m.stats.WaitDuration += m.conf.Clock.Since(start)
+	}()
+
+	for m.conf.MaxActive > 0 && m.active >= m.conf.MaxActive {
+		if m.closed {
+			return connpool.ErrPoolClosed
+		}
+		changed := m.changed
+		m.mx.Unlock()
+		var timeout bool
+		select {
+		case <-changed:
+		case <-t.C():
+			timeout = true
+		case <-ctx.Done():
+			timeout = true
+		}
+		m.mx.Lock()
+		if timeout {
+			m.stats.TimeoutCount++
+			return connpool.ErrWaitGetConnTimeout
+		}
+	}
+	return nil
+}
+
+// 释放一个活跃计数, 需要加锁调用
+func (m *MockPool) releaseLocked() {
+	m.active--
+	m.notifyLocked()
+}
+
+func (m *MockPool) notifyLocked() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// 检查conn是否为该连接池取出且未放回的conn, 需要加锁调用
+func (m *MockPool) checkBorrowedLocked(conn *connpool.Conn) error {
+	state, ok := m.conns[conn]
+	switch {
+	case !ok:
+		return connpool.ErrConnNotOwned
+	case state == mockConnIdle:
+		return connpool.ErrConnNotBorrowed
+	case state == mockConnClosed:
+		return connpool.ErrConnClosed
+	}
+	return nil
+}
+
+func (m *MockPool) Put(conn *connpool.Conn) error {
+	m.mx.Lock()
+	m.puts = append(m.puts, conn)
+	if err := m.checkBorrowedLocked(conn); err != nil {
+		m.mx.Unlock()
+		return err
+	}
+
+	m.releaseLocked()
+	var closeConn bool
+	if m.closed {
+		m.conns[conn] = mockConnClosed
+		m.stats.CloseCount[connpool.CloseReasonPoolClosed]++
+		closeConn = true
+	} else {
+		m.conns[conn] = mockConnIdle
+		m.idle = append(m.idle, conn)
+	}
+
+	var err error
+	if len(m.putErrs) > 0 {
+		err = m.putErrs[0]
+		m.putErrs = m.putErrs[1:]
+	}
+	m.mx.Unlock()
+
+	if closeConn {
+		m.backend.ConnClose(conn)
+	}
+	return err
+}
+
+func (m *MockPool) Discard(conn *connpool.Conn, reason error) error {
+	m.mx.Lock()
+	m.discards = append(m.discards, DiscardRecord{Conn: conn, Reason: reason})
+	if err := m.checkBorrowedLocked(conn); err != nil {
+		m.mx.Unlock()
+		return err
+	}
+
+	m.releaseLocked()
+	m.conns[conn] = mockConnClosed
+	m.stats.CloseCount[connpool.CloseReasonDiscard]++
+
+	var err error
+	if len(m.discardErrs) > 0 {
+		err = m.discardErrs[0]
+		m.discardErrs = m.discardErrs[1:]
+	}
+	m.mx.Unlock()
+
+	m.backend.ConnClose(conn)
+	return err
+}
+
+func (m *MockPool) Close() {
+	m.closeIdle()
+}
+
+// 关闭连接池并关闭所有空闲conn, 返回关闭的数量以及仍未放回的数量
+func (m *MockPool) closeIdle() (int, int) {
+	m.mx.Lock()
+	m.closed = true
+	idle := m.idle
+	m.idle = nil
+	for _, conn := range idle {
+		m.conns[conn] = mockConnClosed
+	}
+	m.stats.CloseCount[connpool.CloseReasonPoolClosed] += int64(len(idle))
+	active := m.active
+	m.notifyLocked()
+	m.mx.Unlock()
+
+	for _, conn := range idle {
+		m.backend.ConnClose(conn)
+	}
+	return len(idle), active
+}
+
+func (m *MockPool) Shutdown(ctx context.Context) (connpool.ShutdownReport, error) {
+	m.mx.Lock()
+	if m.closed {
+		m.mx.Unlock()
+		return connpool.ShutdownReport{}, connpool.ErrPoolClosed
+	}
+	m.draining = true
+
+	var err error
+	for m.active > 0 || m.waiting > 0 {
+		changed := m.changed
+		m.mx.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		m.mx.Lock()
+		if err != nil {
+			break
+		}
+	}
+	m.mx.Unlock()
+
+	closedIdle, leaked := m.closeIdle()
+	return connpool.ShutdownReport{ClosedIdle: closedIdle, Leaked: leaked}, err
+}
+
+func (m *MockPool) Stats() connpool.Stats {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	st := m.stats
+	st.IdleCount = len(m.idle)
+	st.ActiveCount = m.active
+	st.WaitQueueLen = m.waiting
+	st.MinIdle = m.conf.MinIdle
+	st.MaxIdle = m.conf.MaxIdle
+	st.MaxActive = m.conf.MaxActive
+	st.CloseCount = make(map[connpool.CloseReason]int64, len(m.stats.CloseCount))
+	for k, v := range m.stats.CloseCount {
+		st.CloseCount[k] = v
+	}
+	return st
+}
+
+// 修改配置, 只有 MaxActive, MaxWaitConnCount, WaitTimeout 以及 Clock 会影响mock的行为
+func (m *MockPool) UpdateConfig(update func(conf *connpool.Config)) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.closed {
+		return connpool.ErrPoolClosed
+	}
+
+	conf := *m.conf
+	update(&conf)
+	if conf.Clock == nil {
+		conf.Clock = clock.Real()
+	}
+	m.conf = &conf
+	m.notifyLocked()
+	return nil
+}
+
+// 同步创建conn直到空闲conn达到n个, 存在创建失败时返回 *connpool.WarmupError
+func (m *MockPool) Warmup(ctx context.Context, n int) error {
+	m.mx.Lock()
+	if m.closed || m.draining {
+		m.mx.Unlock()
+		return connpool.ErrPoolClosed
+	}
+	need := n - len(m.idle)
+	m.mx.Unlock()
+	if need <= 0 {
+		return nil
+	}
+
+	warmupErr := &connpool.WarmupError{}
+	for i := 0; i < need; i++ {
+		v, err := m.backend.Creator(ctx)
+		m.mx.Lock()
+		if err != nil {
+			m.stats.CreateFailCount++
+			m.mx.Unlock()
+			warmupErr.Errs = append(warmupErr.Errs, err)
+			continue
+		}
+		m.stats.CreateCount++
+		conn := connpool.NewConn(v)
+		m.conns[conn] = mockConnIdle
+		m.idle = append(m.idle, conn)
+		m.mx.Unlock()
+		warmupErr.Created++
+	}
+	if len(warmupErr.Errs) > 0 {
+		return warmupErr
+	}
+	return nil
+}
+
+// 关闭最多n个空闲conn, 返回关闭的数量
+func (m *MockPool) Shrink(n int) int {
+	m.mx.Lock()
+	if n > len(m.idle) {
+		n = len(m.idle)
+	}
+	if n < 0 {
+		n = 0
+	}
+	shrink := append([]*connpool.Conn(nil), m.idle[:n]...)
+	m.idle = m.idle[n:]
+	for _, conn := range shrink {
+		m.conns[conn] = mockConnClosed
+	}
+	m.stats.CloseCount[connpool.CloseReasonNeedless] += int64(n)
+	m.mx.Unlock()
+
+	for _, conn := range shrink {
+		m.backend.ConnClose(conn)
+	}
+	return n
+}
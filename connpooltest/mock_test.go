@@ -0,0 +1,158 @@
+package connpooltest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zlyuancn/connpool"
+	"github.com/zlyuancn/connpool/clock"
+)
+
+func TestMockPoolGetPut(t *testing.T) {
+	m := NewMockPool(nil)
+
+	conn, err := m.Get(context.Background())
+	require.Nil(t, err)
+	require.IsType(t, &FakeConn{}, conn.GetConn())
+	require.Nil(t, m.Put(conn))
+	require.Equal(t, connpool.ErrConnNotBorrowed, m.Put(conn))
+	require.Equal(t, connpool.ErrConnNotOwned, m.Put(connpool.NewConn(nil)))
+
+	// 放回的conn会被复用
+	conn2, err := m.Get(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, conn, conn2)
+	require.Nil(t, m.Discard(conn2, errors.New("broken")))
+	require.Equal(t, connpool.ErrConnClosed, m.Put(conn2))
+
+	require.Len(t, m.Gets(), 2)
+	require.Len(t, m.Puts(), 4)
+	require.Len(t, m.Discards(), 1)
+	require.True(t, AssertNoLeaks(t, m))
+	require.True(t, AssertCountersBalanced(t, m, m.Backend()))
+
+	m.Close()
+	_, err = m.Get(context.Background())
+	require.Equal(t, connpool.ErrPoolClosed, err)
+	require.True(t, AssertAllClosedOnce(t, m.Backend()))
+}
+
+func TestMockPoolQueueErr(t *testing.T) {
+	m := NewMockPool(nil)
+	m.QueueGetErr(connpool.ErrWaitGetConnTimeout, connpool.ErrPoolClosed)
+
+	_, err := m.Get(context.Background())
+	require.Equal(t, connpool.ErrWaitGetConnTimeout, err)
+	_, err = m.Get(context.Background())
+	require.Equal(t, connpool.ErrPoolClosed, err)
+	conn, err := m.Get(context.Background())
+	require.Nil(t, err)
+
+	errTest := errors.New("test")
+	m.QueuePutErr(errTest)
+	require.Equal(t, errTest, m.Put(conn))
+	require.Equal(t, 1, m.Stats().IdleCount)
+
+	// 创建失败的错误来自 Backend
+	m.Backend().FailNext(1, nil)
+	m.QueueDiscardErr(errTest)
+	conn, err = m.Get(context.Background())
+	require.Nil(t, err)
+	_, err = m.Get(context.Background())
+	require.Equal(t, ErrBackendDown, err)
+	require.Equal(t, errTest, m.Discard(conn, nil))
+
+	gets := m.Gets()
+	require.Len(t, gets, 5)
+	require.Equal(t, ErrBackendDown, gets[4].Err)
+	require.Equal(t, int64(1), m.Stats().CreateFailCount)
+}
+
+// 饱和时等待放回或超时
+func TestMockPoolSaturation(t *testing.T) {
+	fake := clock.NewFake(time.Time{})
+	m := NewMockPool(nil)
+	require.Nil(t, m.UpdateConfig(func(conf *connpool.Config) {
+		conf.MaxActive = 1
+		conf.MaxWaitConnCount = 1
+		conf.WaitTimeout = time.Second
+		conf.Clock = fake
+	}))
+
+	conn, err := m.Get(context.Background())
+	require.Nil(t, err)
+
+	got := make(chan error, 1)
+	go func() {
+		c, err := m.Get(context.Background())
+		if err == nil {
+			err = m.Put(c)
+		}
+		got <- err
+	}()
+	fake.BlockUntil(1)
+	require.Equal(t, 1, m.Waiting())
+
+	// 等待数量已达上限
+	_, err = m.Get(context.Background())
+	require.Equal(t, connpool.ErrMaxWaitConnLimit, err)
+
+	require.Nil(t, m.Put(conn))
+	require.Nil(t, <-got)
+
+	conn, err = m.Get(context.Background())
+	require.Nil(t, err)
+	go func() {
+		_, err := m.Get(context.Background())
+		got <- err
+	}()
+	fake.BlockUntil(1)
+	fake.Advance(time.Second)
+	require.Equal(t, connpool.ErrWaitGetConnTimeout, <-got)
+
+	st := m.Stats()
+	require.Equal(t, int64(2), st.WaitCount)
+	require.Equal(t, int64(1), st.TimeoutCount)
+	require.Equal(t, 1, st.MaxActive)
+	require.Nil(t, m.Put(conn))
+}
+
+func TestMockPoolShutdown(t *testing.T) {
+	m := NewMockPool(nil)
+	conn, err := m.Get(context.Background())
+	require.Nil(t, err)
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		_ = m.Put(conn)
+	}()
+	report, err := m.Shutdown(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, connpool.ShutdownReport{ClosedIdle: 1}, report)
+	require.True(t, AssertAllClosedOnce(t, m.Backend()))
+
+	_, err = m.Shutdown(context.Background())
+	require.Equal(t, connpool.ErrPoolClosed, err)
+	require.Equal(t, connpool.ErrPoolClosed, m.UpdateConfig(func(conf *connpool.Config) {}))
+}
+
+func TestMockPoolWarmupShrink(t *testing.T) {
+	m := NewMockPool(nil)
+	m.Backend().FailNext(1, nil)
+
+	err := m.Warmup(context.Background(), 3)
+	var warmupErr *connpool.WarmupError
+	require.True(t, errors.As(err, &warmupErr))
+	require.Equal(t, 2, warmupErr.Created)
+	require.True(t, errors.Is(err, ErrBackendDown))
+	require.Nil(t, m.Warmup(context.Background(), 3))
+	require.Equal(t, 3, m.Stats().IdleCount)
+
+	require.Equal(t, 2, m.Shrink(2))
+	require.Equal(t, 1, m.Shrink(5))
+	require.Equal(t, int64(3), m.Stats().CloseCount[connpool.CloseReasonNeedless])
+	require.True(t, AssertAllClosedOnce(t, m.Backend()))
+}
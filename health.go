@@ -0,0 +1,163 @@
+package connpool
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zlyuancn/connpool/clock"
+)
+
+const (
+	// 单次健康检查超时
+	defHealthCheckTimeout = time.Second * 3
+	// 空闲检查时的并发数
+	defIdleCheckConcurrency = 4
+)
+
+// 健康检查器
+type HealthChecker interface {
+	// 检查conn是否健康, 返回错误表示conn已不可用
+	Check(ctx context.Context, conn *Conn) error
+}
+
+// 函数形式的健康检查器
+type HealthCheckFunc func(ctx context.Context, conn *Conn) error
+
+func (f HealthCheckFunc) Check(ctx context.Context, conn *Conn) error {
+	return f(ctx, conn)
+}
+
+// 健康检查策略, 只有设置了 Config.HealthChecker 才会生效
+type HealthCheckConfig struct {
+	TestOnBorrow     bool          // 从空闲列表取出conn时检查, 等待中获取到的conn刚被放回或刚创建, 不会检查
+	TestOnReturn     bool          // 放回conn时检查
+	TestWhileIdle    bool          // 检查空闲时对空闲conn进行检查
+	MinIdleTime      time.Duration // 取出时和空闲检查时, conn空闲或距上次检查超过该时间才会检查, 小于1表示总是检查
+	Timeout          time.Duration // 单次检查超时
+	IdleConcurrency  int           // 空闲检查时的并发数, 同时从空闲列表中移出检查的conn不超过该值
+	IdleCheckTimeout time.Duration // 单轮空闲检查的总时间预算, 超过后正在检查的conn视为未检查放回, 剩余的conn留到下一轮检查, 默认为 CheckIdleInterval
+}
+
+func (h *HealthCheckConfig) check(conf *Config) {
+	if h.Timeout < 1 {
+		h.Timeout = defHealthCheckTimeout
+	}
+	if h.IdleConcurrency < 1 {
+		h.IdleConcurrency = defIdleCheckConcurrency
+	}
+	if h.IdleCheckTimeout < 1 {
+		h.IdleCheckTimeout = conf.CheckIdleInterval
+	}
+}
+
+// conn是否需要检查, 空闲或距上次检查超过 MinIdleTime 才需要检查
+func (c *ConnectPool) needHealthCheck(conn *Conn, idleSince int64) bool {
+	last := idleSince
+	if conn.checkTime > last {
+		last = conn.checkTime
+	}
+	return time.Duration(c.now().UnixNano()-last) >= c.config().HealthCheck.MinIdleTime
+}
+
+// 检查conn是否健康, 成功时记录检查时间
+func (c *ConnectPool) healthCheck(ctx context.Context, conn *Conn) bool {
+	ctx, cancel := clock.WithTimeout(ctx, c.config().Clock, c.config().HealthCheck.Timeout)
+	defer cancel()
+
+	if err := c.config().HealthChecker.Check(ctx, conn); err != nil {
+		return false
+	}
+	conn.checkTime = c.now().UnixNano()
+	return true
+}
+
+// 取出时检查, idleSince为conn放入空闲列表的时间
+func (c *ConnectPool) healthCheckOnBorrow(ctx context.Context, conn *Conn, idleSince int64) bool {
+	if c.config().HealthChecker == nil || !c.config().HealthCheck.TestOnBorrow || !c.needHealthCheck(conn, idleSince) {
+		return true
+	}
+	return c.healthCheck(ctx, conn)
+}
+
+// 放回时检查
+func (c *ConnectPool) healthCheckOnReturn(conn *Conn) bool {
+	if c.config().HealthChecker == nil || !c.config().HealthCheck.TestOnReturn {
+		return true
+	}
+	return c.healthCheck(c.baseCtx, conn)
+}
+
+// 检查空闲的conn, 每次最多从空闲列表中移出 IdleConcurrency 个conn并发检查, 检查完成后放回再取下一批
+func (c *ConnectPool) checkIdleHealth() {
+	if c.config().HealthChecker == nil || !c.config().HealthCheck.TestWhileIdle {
+		return
+	}
+
+	ctx, cancel := clock.WithTimeout(c.baseCtx, c.config().Clock, c.config().HealthCheck.IdleCheckTimeout)
+	defer cancel()
+
+	checked := make(map[*Conn]struct{}) // 本轮已检查的conn, 放回后不再重复检查
+	for ctx.Err() == nil {
+		conns := c.takeIdleForCheck(checked, c.config().HealthCheck.IdleConcurrency)
+		if len(conns) == 0 {
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, conn := range conns {
+			checked[conn] = struct{}{}
+			wg.Add(1)
+			go func(conn *Conn) {
+				defer wg.Done()
+
+				// 超出时间预算导致的失败视为未检查, 放回空闲列表等待下一轮
+				if !c.healthCheck(ctx, conn) && ctx.Err() == nil {
+					c.closeConn(conn, CloseReasonUnhealthy)
+					return
+				}
+				c.returnIdleConn(conn)
+			}(conn)
+		}
+		wg.Wait()
+	}
+}
+
+// 从空闲列表中移出最多n个需要检查的conn, 跳过本轮已检查的conn
+func (c *ConnectPool) takeIdleForCheck(checked map[*Conn]struct{}, n int) []*Conn {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	var conns []*Conn
+	for e := c.connList.Front(); e != nil && len(conns) < n; {
+		next := e.Next()
+		conn := e.Value.(*Conn)
+		if _, ok := checked[conn]; !ok && c.needHealthCheck(conn, conn.putTime) {
+			c.connList.Remove(e)
+			conns = append(conns, conn)
+		}
+		e = next
+	}
+	return conns
+}
+
+// 将检查后的conn放回空闲列表, 保留其放入时间
+func (c *ConnectPool) returnIdleConn(conn *Conn) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.isClose() {
+		go c.closeConn(conn, CloseReasonPoolClosed)
+		return
+	}
+
+	// 立即使用这个conn
+	idleSince := conn.putTime
+	conn.putTime = 0
+	if c.useConn(conn) {
+		return
+	}
+
+	conn.putTime = idleSince
+	c.pushIdleConn(conn, false)
+}
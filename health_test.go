@@ -0,0 +1,242 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zlyuancn/connpool/clock"
+)
+
+type testHealthConn struct {
+	healthy int32
+}
+
+func makeHealthTestConfig() *Config {
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MinIdle = 1
+	conf.BatchIncrement = 1
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		return &testHealthConn{healthy: 1}, nil
+	}
+	return conf
+}
+
+// 取出时检查
+func TestHealthCheckOnBorrow(t *testing.T) {
+	conf := makeHealthTestConfig()
+	checkNum := int32(0)
+	conf.HealthChecker = HealthCheckFunc(func(ctx context.Context, conn *Conn) error {
+		atomic.AddInt32(&checkNum, 1)
+		if atomic.LoadInt32(&conn.GetConn().(*testHealthConn).healthy) == 0 {
+			return errors.New("ping失败")
+		}
+		return nil
+	})
+	conf.HealthCheck.TestOnBorrow = true
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&checkNum))
+
+	atomic.StoreInt32(&conn.GetConn().(*testHealthConn).healthy, 0)
+	p.Put(conn)
+
+	conn2, err := p.Get(context.Background()) // 取出时检查失败, 重新创建
+	require.Nil(t, err)
+	require.NotEqual(t, conn, conn2)
+	time.Sleep(time.Millisecond * 100) // 关闭是通过goroutine的
+	require.Equal(t, int64(1), p.Stats().CloseCount[CloseReasonUnhealthy])
+}
+
+// 检查超时由时钟控制
+func TestHealthCheckTimeoutClock(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	started := make(chan struct{}, 1)
+	errs := make(chan error, 1)
+	conf := makeHealthTestConfig()
+	conf.Clock = clk
+	checkNum := int32(0)
+	conf.HealthChecker = HealthCheckFunc(func(ctx context.Context, conn *Conn) error {
+		if atomic.AddInt32(&checkNum, 1) > 1 {
+			return nil
+		}
+		started <- struct{}{} // 检查超时的timer在调用前创建
+		<-ctx.Done()          // 第一次检查直到超时
+		errs <- ctx.Err()
+		return ctx.Err()
+	})
+	conf.HealthCheck.TestOnBorrow = true
+	conf.HealthCheck.Timeout = time.Second
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	got := make(chan error, 1)
+	go func() {
+		conn, err := p.Get(context.Background())
+		if err == nil {
+			err = p.Put(conn)
+		}
+		got <- err
+	}()
+	<-started
+	clk.Advance(time.Second)
+	require.Equal(t, context.DeadlineExceeded, <-errs)
+	require.Nil(t, <-got) // 检查失败后重新创建
+}
+
+// 空闲时间不够时不检查
+func TestHealthCheckMinIdleTime(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	conf := makeHealthTestConfig()
+	conf.Clock = clk
+	checkNum := int32(0)
+	conf.HealthChecker = HealthCheckFunc(func(ctx context.Context, conn *Conn) error {
+		atomic.AddInt32(&checkNum, 1)
+		return nil
+	})
+	conf.HealthCheck.TestOnBorrow = true
+	conf.HealthCheck.MinIdleTime = time.Second
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	p.Put(conn)
+	conn, err = p.Get(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, int32(0), atomic.LoadInt32(&checkNum))
+	p.Put(conn)
+
+	clk.Advance(time.Second * 2) // 空闲超过 MinIdleTime
+	_, err = p.Get(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&checkNum))
+}
+
+// 放回时检查
+func TestHealthCheckOnReturn(t *testing.T) {
+	conf := makeHealthTestConfig()
+	conf.HealthChecker = HealthCheckFunc(func(ctx context.Context, conn *Conn) error {
+		return errors.New("ping失败")
+	})
+	conf.HealthCheck.TestOnReturn = true
+	closeNum := int32(0)
+	conf.ConnClose = func(conn *Conn) {
+		atomic.AddInt32(&closeNum, 1)
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	p.Put(conn)
+	time.Sleep(time.Millisecond * 100) // 关闭是通过goroutine的
+	require.Equal(t, int32(1), atomic.LoadInt32(&closeNum))
+	require.Equal(t, int64(1), p.Stats().CloseCount[CloseReasonUnhealthy])
+}
+
+// 空闲时检查
+func TestHealthCheckWhileIdle(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	conf := makeHealthTestConfig()
+	conf.Clock = clk
+	conf.MinIdle = 4
+	conf.CheckIdleInterval = time.Second
+	checkNum := int32(0)
+	conf.HealthChecker = HealthCheckFunc(func(ctx context.Context, conn *Conn) error {
+		if atomic.AddInt32(&checkNum, 1) == 1 {
+			return errors.New("ping失败")
+		}
+		return nil
+	})
+	conf.HealthCheck.TestWhileIdle = true
+	conf.HealthCheck.IdleConcurrency = 2
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+	require.Eventually(t, func() bool { return p.Stats().IdleCount == 4 }, time.Second, time.Millisecond*10)
+
+	clk.Advance(time.Second) // 触发检查
+	// 检查失败的conn被移除后重新补充
+	require.Eventually(t, func() bool {
+		st := p.Stats()
+		return st.IdleCount == 4 && st.CloseCount[CloseReasonUnhealthy] == 1
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, int32(4), atomic.LoadInt32(&checkNum))
+}
+
+// 空闲检查每次最多取出 IdleConcurrency 个conn
+func TestHealthCheckWhileIdleBatch(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	conf := makeHealthTestConfig()
+	conf.Clock = clk
+	conf.MinIdle = 4
+	conf.CheckIdleInterval = time.Second
+	conf.HealthChecker = HealthCheckFunc(func(ctx context.Context, conn *Conn) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+	conf.HealthCheck.TestWhileIdle = true
+	conf.HealthCheck.IdleConcurrency = 2
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+	require.Eventually(t, func() bool { return p.Stats().IdleCount == 4 }, time.Second, time.Millisecond*10)
+
+	clk.Advance(time.Second) // 触发检查
+	for i := 0; i < 2; i++ {
+		<-started
+		<-started
+		require.Equal(t, 2, p.Stats().IdleCount) // 检查中的conn不超过并发数
+		release <- struct{}{}
+		release <- struct{}{}
+	}
+	require.Eventually(t, func() bool { return p.Stats().IdleCount == 4 }, time.Second, time.Millisecond*10)
+}
+
+// 空闲检查超出时间预算时视为未检查, 不关闭conn
+func TestHealthCheckWhileIdleTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	started := make(chan struct{}, 1)
+	conf := makeHealthTestConfig()
+	conf.Clock = clk
+	conf.MinIdle = 3
+	conf.CheckIdleInterval = time.Second
+	checkNum := int32(0)
+	conf.HealthChecker = HealthCheckFunc(func(ctx context.Context, conn *Conn) error {
+		atomic.AddInt32(&checkNum, 1)
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	conf.HealthCheck.TestWhileIdle = true
+	conf.HealthCheck.Timeout = time.Second
+	conf.HealthCheck.IdleConcurrency = 1
+	conf.HealthCheck.IdleCheckTimeout = time.Millisecond * 500
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+	require.Eventually(t, func() bool { return p.Stats().IdleCount == 3 }, time.Second, time.Millisecond*10)
+
+	clk.Advance(time.Second) // 触发检查
+	<-started
+	clk.Advance(time.Millisecond * 500) // 时间预算用完, 单次检查还未超时
+	require.Eventually(t, func() bool { return p.Stats().IdleCount == 3 }, time.Second, time.Millisecond*10)
+	require.Equal(t, int32(1), atomic.LoadInt32(&checkNum)) // 剩余的conn留到下一轮检查
+	require.Equal(t, int64(0), p.Stats().CloseCount[CloseReasonUnhealthy])
+}
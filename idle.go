@@ -0,0 +1,65 @@
+package connpool
+
+import (
+	"container/list"
+)
+
+// 空闲conn的选择策略
+type IdleStrategy int
+
+const (
+	IdleLIFO          IdleStrategy = iota // 后进先出, 优先取出最近放回的conn, 多余的conn会因为长期空闲而被释放
+	IdleFIFO                              // 先进先出, 优先取出空闲最久的conn, 所有conn被均匀使用, 可以避免负载均衡器断开空闲的连接
+	IdleOldestCreated                     // 优先取出创建时间最早的conn
+)
+
+func (s IdleStrategy) String() string {
+	switch s {
+	case IdleLIFO:
+		return "lifo"
+	case IdleFIFO:
+		return "fifo"
+	case IdleOldestCreated:
+		return "oldest_created"
+	}
+	return "unknown"
+}
+
+// 放入空闲conn列表, 需要加锁调用
+//
+// 列表头部的conn会被优先取出, 缩容时释放的conn见 evictCandidate.
+// recent 表示conn刚被使用过, 后进先出时会放在列表头部, 新建的conn和空闲检查后的conn放在列表末尾.
+func (c *ConnectPool) pushIdleConn(conn *Conn, recent bool) {
+	switch c.config().IdleStrategy {
+	case IdleFIFO:
+		c.connList.PushBack(conn)
+	case IdleOldestCreated:
+		// 按创建时间从早到晚排列, 新建的conn一般最晚, 所以从末尾开始找
+		for e := c.connList.Back(); e != nil; e = e.Prev() {
+			if e.Value.(*Conn).createTime <= conn.createTime {
+				c.connList.InsertAfter(conn, e)
+				return
+			}
+		}
+		c.connList.PushFront(conn)
+	default:
+		if recent {
+			c.connList.PushFront(conn)
+		} else {
+			c.connList.PushBack(conn)
+		}
+	}
+}
+
+// 返回缩容时优先释放的空闲conn, 需要加锁调用
+//
+// 后进先出时列表末尾是最久未使用的conn, 先进先出时列表头部是空闲最久的conn,
+// 按创建时间取出时列表头部是创建最早的conn, 它最先达到 MaxLifetime.
+func (c *ConnectPool) evictCandidate() *list.Element {
+	switch c.config().IdleStrategy {
+	case IdleFIFO, IdleOldestCreated:
+		return c.connList.Front()
+	default:
+		return c.connList.Back()
+	}
+}
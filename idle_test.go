@@ -0,0 +1,123 @@
+package connpool
+
+import (
+	"container/list"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdleStrategy(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MinIdle = 1
+	conf.CheckIdleInterval = time.Minute
+	for _, strategy := range []IdleStrategy{IdleLIFO, IdleFIFO, IdleOldestCreated} {
+		conf.IdleStrategy = strategy
+		pool, err := NewConnectPool(conf)
+		require.Nil(t, err)
+		p := pool.(*ConnectPool)
+		time.Sleep(time.Millisecond * 100) // 等待初始conn创建完毕后清空
+		p.mx.Lock()
+		p.connList = list.New()
+
+		// 创建时间依次为 3, 1, 2, 依次作为新建的conn放入后, 再作为刚使用过的conn放入一个
+		now := time.Now().UnixNano()
+		conns := make([]*Conn, 4)
+		for i, offset := range []int64{3, 1, 2, 4} {
+			conns[i] = makeConn(p, testConn{})
+			conns[i].createTime = now + offset
+		}
+		for _, conn := range conns[:3] {
+			p.pushIdleConn(conn, false)
+		}
+		p.pushIdleConn(conns[3], true)
+
+		var got []*Conn
+		for conn := p.popFrontConn(); conn != nil; conn = p.popFrontConn() {
+			got = append(got, conn)
+		}
+		p.mx.Unlock()
+		pool.Close()
+
+		switch strategy {
+		case IdleLIFO:
+			require.Equal(t, []*Conn{conns[3], conns[0], conns[1], conns[2]}, got)
+		case IdleFIFO:
+			require.Equal(t, []*Conn{conns[0], conns[1], conns[2], conns[3]}, got)
+		case IdleOldestCreated:
+			require.Equal(t, []*Conn{conns[1], conns[2], conns[0], conns[3]}, got)
+		}
+	}
+}
+
+// 先进先出时所有conn被轮流使用
+func TestIdleFIFO(t *testing.T) {
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MinIdle = 2
+	conf.MaxIdle = 2
+	conf.CheckIdleInterval = time.Minute
+	conf.IdleStrategy = IdleFIFO
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+	time.Sleep(time.Millisecond * 100)
+
+	used := make(map[uint64]int)
+	for i := 0; i < 4; i++ {
+		conn, err := p.Get(context.Background())
+		require.Nil(t, err)
+		used[conn.ID()]++
+		require.Nil(t, p.Put(conn))
+	}
+	require.Len(t, used, 2)
+	for _, n := range used {
+		require.Equal(t, 2, n)
+	}
+}
+
+// 缩容时根据策略选择释放的conn
+func TestShrinkStrategy(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MinIdle = 1
+	conf.CheckIdleInterval = time.Minute
+	for _, strategy := range []IdleStrategy{IdleLIFO, IdleFIFO, IdleOldestCreated} {
+		conf.IdleStrategy = strategy
+		pool, err := NewConnectPool(conf)
+		require.Nil(t, err)
+		p := pool.(*ConnectPool)
+		time.Sleep(time.Millisecond * 100) // 等待初始conn创建完毕后清空
+		p.mx.Lock()
+		p.connList = list.New()
+
+		// 创建时间依次为 3, 1, 2, 依次作为刚使用过的conn放入
+		now := time.Now().UnixNano()
+		conns := make([]*Conn, 3)
+		for i, offset := range []int64{3, 1, 2} {
+			conns[i] = makeConn(p, testConn{})
+			conns[i].createTime = now + offset
+			p.pushIdleConn(conns[i], true)
+		}
+		p.mx.Unlock()
+
+		require.Equal(t, 1, p.Shrink(1))
+		p.mx.Lock()
+		var got []*Conn
+		for e := p.connList.Front(); e != nil; e = e.Next() {
+			got = append(got, e.Value.(*Conn))
+		}
+		p.mx.Unlock()
+		pool.Close()
+
+		switch strategy {
+		case IdleLIFO: // 释放最久未使用的conns[0]
+			require.Equal(t, []*Conn{conns[2], conns[1]}, got)
+		case IdleFIFO: // 释放空闲最久的conns[0]
+			require.Equal(t, []*Conn{conns[1], conns[2]}, got)
+		case IdleOldestCreated: // 释放创建最早的conns[1]
+			require.Equal(t, []*Conn{conns[2], conns[0]}, got)
+		}
+	}
+}
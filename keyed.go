@@ -0,0 +1,584 @@
+package connpool
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zlyuancn/connpool/clock"
+)
+
+// 带key的创造者, key为子连接池的key, 如节点地址或租户的DSN
+type KeyedCreator func(ctx context.Context, key string) (interface{}, error)
+
+type KeyedConfig struct {
+	Config                          // 每个key的子连接池配置, 其中的 Creator 由 KeyedConfig.Creator 接管, MaxActive 为单个key的限制
+	TotalMaxActive int              // 所有key共享的最大活跃连接数, 小于1表示不限制
+	MaxKeys        int              // 最多保留的子连接池数量, 超过时淘汰最久未使用的子连接池, 小于1表示不限制
+	KeyIdleTimeout time.Duration    // 子连接池超过该时间未被使用时被淘汰, 小于1表示不按时间淘汰
+	OnKeyEvict     func(key string) // 子连接池被淘汰时的回调
+	Creator        KeyedCreator
+}
+
+func NewKeyedConfig() *KeyedConfig {
+	return &KeyedConfig{
+		Config: *NewConfig(),
+	}
+}
+
+// 检查配置, 返回检查后的子连接池配置
+func (conf *KeyedConfig) check() (*Config, error) {
+	if conf.Creator == nil {
+		return nil, errors.New("未设置 Creator")
+	}
+
+	base := conf.Config
+	base.Creator = func(ctx context.Context) (interface{}, error) { return nil, errors.New("未指定key") }
+	if err := base.Check(); err != nil {
+		return nil, err
+	}
+	return &base, nil
+}
+
+// 一个key对应的子连接池
+type keyedEntry struct {
+	key   string
+	pool  *ConnectPool  // 子连接池, ready关闭前为nil
+	ready chan struct{} // 子连接池创建完成的信号
+	err   error         // 子连接池创建失败的错误
+
+	// 以下字段由 KeyedConnectPool.mx 保护
+	e       *list.Element // 在LRU列表中的位置
+	active  int           // 正在获取以及取出未放回的conn数量, 大于0时不会被淘汰
+	lastUse time.Time     // 最后一次被使用的时间
+}
+
+// 按key划分的连接池, 每个key在第一次 Get 时创建一个子连接池
+//
+// 没有活跃conn的子连接池超过 KeyIdleTimeout 未被使用, 或子连接池数量超过 MaxKeys 时, 最久未使用的子连接池会被关闭并移除,
+// 之后再次使用该key时重新创建. 有活跃conn的子连接池不会被淘汰, 因此数量可能暂时超过 MaxKeys.
+type KeyedConnectPool struct {
+	conf *KeyedConfig
+
+	mx         sync.Mutex
+	base       *Config                      // 子连接池配置, 由 UpdateConfig 修改
+	entries    map[string]*keyedEntry       // 所有子连接池
+	lru        *list.List                   // 子连接池的使用顺序, 最近使用的在前
+	byPool     map[*ConnectPool]*keyedEntry // 根据conn所属的子连接池找到key
+	borrowed   map[*Conn]*keyedEntry        // 通过该连接池取出未放回的conn
+	evicted    Stats                        // 已淘汰子连接池的累计计数
+	activeLock chan struct{}                // 所有key共享的活跃锁, 未限制时为nil
+
+	close chan struct{} // 关闭信号
+}
+
+func NewKeyedConnectPool(conf *KeyedConfig) (*KeyedConnectPool, error) {
+	base, err := conf.check()
+	if err != nil {
+		return nil, fmt.Errorf("配置检查失败: %v", err)
+	}
+
+	pool := &KeyedConnectPool{
+		conf:     conf,
+		base:     base,
+		entries:  make(map[string]*keyedEntry),
+		lru:      list.New(),
+		byPool:   make(map[*ConnectPool]*keyedEntry),
+		borrowed: make(map[*Conn]*keyedEntry),
+		close:    make(chan struct{}),
+	}
+	if conf.TotalMaxActive > 0 {
+		pool.activeLock = make(chan struct{}, conf.TotalMaxActive)
+		for i := 0; i < conf.TotalMaxActive; i++ {
+			pool.activeLock <- struct{}{}
+		}
+	}
+	if conf.KeyIdleTimeout > 0 {
+		go pool.evictLoop(base.Clock.NewTicker(base.CheckIdleInterval))
+	}
+	return pool, nil
+}
+
+// 生成key的子连接池配置, 需要加锁调用
+func (c *KeyedConnectPool) makeKeyConfig(key string) *Config {
+	conf := *c.base
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		return c.conf.Creator(ctx, key)
+	}
+	conf.onReclaim = c.reclaimed
+	return &conf
+}
+
+// 子连接池回收了泄漏的conn, 释放其活跃计数以及共享的活跃锁
+func (c *KeyedConnectPool) reclaimed(conn *Conn) {
+	c.mx.Lock()
+	e, ok := c.borrowed[conn]
+	delete(c.borrowed, conn)
+	c.mx.Unlock()
+
+	if ok {
+		c.releaseEntry(e)
+	}
+}
+
+// 获取key的conn, 子连接池不存在时会创建
+func (c *KeyedConnectPool) Get(ctx context.Context, key string) (*Conn, error) {
+	if c.isClose() {
+		return nil, ErrPoolClosed
+	}
+	if err := c.acquireActiveLock(ctx); err != nil {
+		return nil, err
+	}
+
+	e, err := c.acquireEntry(ctx, key)
+	if err != nil {
+		c.putActiveLock()
+		return nil, err
+	}
+
+	conn, err := e.pool.Get(ctx)
+	if err != nil {
+		c.releaseEntry(e)
+		return nil, err
+	}
+
+	c.mx.Lock()
+	c.borrowed[conn] = e
+	c.mx.Unlock()
+	return conn, nil
+}
+
+// 获取key的子连接池并增加其活跃计数, 不存在时在后台创建, ctx结束时停止等待创建
+func (c *KeyedConnectPool) acquireEntry(ctx context.Context, key string) (*keyedEntry, error) {
+	c.mx.Lock()
+	if c.isClose() {
+		c.mx.Unlock()
+		return nil, ErrPoolClosed
+	}
+
+	e, ok := c.entries[key]
+	if !ok {
+		e = &keyedEntry{key: key, ready: make(chan struct{}), lastUse: c.base.Clock.Now()}
+		e.e = c.lru.PushFront(e)
+		c.entries[key] = e
+		go c.createEntry(e, c.makeKeyConfig(key))
+	}
+	e.active++
+	e.lastUse = c.base.Clock.Now()
+	c.lru.MoveToFront(e.e)
+	evicted := c.evictExcess()
+	c.mx.Unlock()
+
+	c.closeEntries(evicted)
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		c.mx.Lock()
+		e.active--
+		c.mx.Unlock()
+		return nil, ErrWaitGetConnTimeout
+	}
+	if e.err != nil {
+		c.mx.Lock()
+		e.active--
+		c.mx.Unlock()
+		return nil, e.err
+	}
+	return e, nil
+}
+
+// 创建子连接池, 开启 WaitFirstConn 时会等待第一个conn, 完成后关闭 e.ready
+func (c *KeyedConnectPool) createEntry(e *keyedEntry, conf *Config) {
+	p, err := NewConnectPool(conf)
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	defer close(e.ready)
+
+	if err != nil {
+		e.err = fmt.Errorf("创建 %s 的连接池失败: %v", e.key, err)
+		c.removeEntry(e)
+		return
+	}
+	if c.isClose() { // 创建期间被关闭
+		e.err = ErrPoolClosed
+		c.removeEntry(e)
+		go p.Close()
+		return
+	}
+	e.pool = p.(*ConnectPool)
+	c.byPool[e.pool] = e
+}
+
+// 减少子连接池的活跃计数并放回共享的活跃锁
+func (c *KeyedConnectPool) releaseEntry(e *keyedEntry) {
+	c.mx.Lock()
+	e.active--
+	e.lastUse = c.base.Clock.Now()
+	c.mx.Unlock()
+	c.putActiveLock()
+}
+
+// 放回conn, 会放回到conn所属的子连接池
+func (c *KeyedConnectPool) Put(conn *Conn) error {
+	e, borrowed, err := c.lookup(conn)
+	if err != nil {
+		return err
+	}
+	err = e.pool.Put(conn)
+	if borrowed {
+		c.releaseEntry(e)
+	}
+	return err
+}
+
+// 丢弃已损坏的conn, 参考 IConnectPool
+func (c *KeyedConnectPool) Discard(conn *Conn, reason error) error {
+	e, borrowed, err := c.lookup(conn)
+	if err != nil {
+		return err
+	}
+	err = e.pool.Discard(conn, reason)
+	if borrowed {
+		c.releaseEntry(e)
+	}
+	return err
+}
+
+// 找到conn所属的子连接池, 同时返回conn是否为通过该连接池取出且未放回, 是时会将其移除
+func (c *KeyedConnectPool) lookup(conn *Conn) (*keyedEntry, bool, error) {
+	if conn == nil {
+		return nil, false, ErrConnNotOwned
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	e, ok := c.byPool[conn.pool]
+	if !ok {
+		return nil, false, ErrConnNotOwned
+	}
+	// 重复放回的conn交给子连接池返回错误
+	_, borrowed := c.borrowed[conn]
+	delete(c.borrowed, conn)
+	return e, borrowed, nil
+}
+
+// 获取conn所属的key, conn不属于该连接池时返回空字符串和false
+func (c *KeyedConnectPool) KeyOf(conn *Conn) (string, bool) {
+	if conn == nil {
+		return "", false
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if e, ok := c.byPool[conn.pool]; ok {
+		return e.key, true
+	}
+	return "", false
+}
+
+// 获取当前存在的key, 最近使用的在前
+func (c *KeyedConnectPool) Keys() []string {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	keys := make([]string, 0, c.lru.Len())
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(*keyedEntry).key)
+	}
+	return keys
+}
+
+// 获取已创建完成的子连接池
+func (c *KeyedConnectPool) readyEntries() []*keyedEntry {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	entries := make([]*keyedEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		if e.pool != nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// 移除子连接池, 需要加锁调用
+func (c *KeyedConnectPool) removeEntry(e *keyedEntry) {
+	if c.entries[e.key] != e {
+		return
+	}
+	delete(c.entries, e.key)
+	c.lru.Remove(e.e)
+	if e.pool != nil {
+		delete(c.byPool, e.pool)
+	}
+}
+
+// 子连接池是否可以被淘汰, 需要加锁调用
+func (e *keyedEntry) evictable() bool {
+	return e.active == 0 && e.pool != nil
+}
+
+// 淘汰超过 MaxKeys 的子连接池, 返回被淘汰的子连接池, 需要加锁调用
+func (c *KeyedConnectPool) evictExcess() []*keyedEntry {
+	if c.conf.MaxKeys < 1 {
+		return nil
+	}
+
+	var evicted []*keyedEntry
+	for el := c.lru.Back(); el != nil && len(c.entries) > c.conf.MaxKeys; {
+		e := el.Value.(*keyedEntry)
+		el = el.Prev()
+		if e.evictable() {
+			c.removeEntry(e)
+			evicted = append(evicted, e)
+		}
+	}
+	return evicted
+}
+
+// 淘汰超过 KeyIdleTimeout 未被使用的子连接池
+func (c *KeyedConnectPool) evictIdle() {
+	c.mx.Lock()
+	now := c.base.Clock.Now()
+	var evicted []*keyedEntry
+	for el := c.lru.Back(); el != nil; { // 放回时只更新使用时间不调整顺序, 需要检查所有子连接池
+		e := el.Value.(*keyedEntry)
+		el = el.Prev()
+		if now.Sub(e.lastUse) >= c.conf.KeyIdleTimeout && e.evictable() {
+			c.removeEntry(e)
+			evicted = append(evicted, e)
+		}
+	}
+	c.mx.Unlock()
+
+	c.closeEntries(evicted)
+}
+
+// 关闭被淘汰的子连接池, 并保留其累计计数
+func (c *KeyedConnectPool) closeEntries(evicted []*keyedEntry) {
+	for _, e := range evicted {
+		e.pool.Close()
+		st := e.pool.Stats()
+
+		c.mx.Lock()
+		c.evicted.GetCount += st.GetCount
+		c.evicted.WaitCount += st.WaitCount
+		c.evicted.WaitDuration += st.WaitDuration
+		c.evicted.TimeoutCount += st.TimeoutCount
+		c.evicted.CreateCount += st.CreateCount
+		c.evicted.CreateFailCount += st.CreateFailCount
+		if c.evicted.CloseCount == nil {
+			c.evicted.CloseCount = make(map[CloseReason]int64, closeReasonCount)
+		}
+		for reason, n := range st.CloseCount {
+			c.evicted.CloseCount[reason] += n
+		}
+		c.mx.Unlock()
+
+		if c.conf.OnKeyEvict != nil {
+			c.conf.OnKeyEvict(e.key)
+		}
+	}
+}
+
+// 定时淘汰空闲的子连接池
+func (c *KeyedConnectPool) evictLoop(t clock.Ticker) {
+	defer t.Stop()
+
+	for {
+		select {
+		case <-c.close:
+			return
+		case <-t.C():
+			c.evictIdle()
+		}
+	}
+}
+
+func (c *KeyedConnectPool) Close() {
+	if !c.markClose() {
+		return
+	}
+	for _, e := range c.readyEntries() {
+		e.pool.Close()
+	}
+}
+
+// 优雅关闭所有子连接池, 报告为所有子连接池的汇总, 返回第一个遇到的错误
+func (c *KeyedConnectPool) Shutdown(ctx context.Context) (ShutdownReport, error) {
+	if !c.markClose() {
+		return ShutdownReport{}, ErrPoolClosed
+	}
+
+	var report ShutdownReport
+	var firstErr error
+	var mx sync.Mutex
+	var wg sync.WaitGroup
+	for _, e := range c.readyEntries() {
+		wg.Add(1)
+		go func(e *keyedEntry) {
+			defer wg.Done()
+			r, err := e.pool.Shutdown(ctx)
+			mx.Lock()
+			report.ClosedIdle += r.ClosedIdle
+			report.Leaked += r.Leaked
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("key %s: %w", e.key, err)
+			}
+			mx.Unlock()
+		}(e)
+	}
+	wg.Wait()
+	return report, firstErr
+}
+
+// 获取所有子连接池的汇总统计快照, 累计计数包括已淘汰的子连接池
+//
+// BreakerState 在所有子连接池熔断时为 BreakerOpen, 部分熔断时为 BreakerHalfOpen,
+// MaxActive 为 TotalMaxActive, MinIdle 和 MaxIdle 为当前所有子连接池之和
+func (c *KeyedConnectPool) Stats() Stats {
+	st := Stats{CloseCount: make(map[CloseReason]int64, closeReasonCount)}
+	c.mx.Lock()
+	st.merge(c.evicted)
+	c.mx.Unlock()
+
+	entries := c.readyEntries()
+	open := 0
+	for _, e := range entries {
+		s := e.pool.Stats()
+		st.MinIdle += s.MinIdle
+		st.MaxIdle += s.MaxIdle
+		st.merge(s)
+		if s.BreakerState != BreakerClosed {
+			open++
+			st.BreakerState = BreakerHalfOpen
+		}
+	}
+	if len(entries) > 0 && open == len(entries) {
+		st.BreakerState = BreakerOpen
+	}
+	st.MaxActive = c.conf.TotalMaxActive
+	if st.MaxActive < 0 {
+		st.MaxActive = 0
+	}
+	return st
+}
+
+// 获取每个子连接池的统计快照
+func (c *KeyedConnectPool) KeyStats() map[string]Stats {
+	entries := c.readyEntries()
+	m := make(map[string]Stats, len(entries))
+	for _, e := range entries {
+		m[e.key] = e.pool.Stats()
+	}
+	return m
+}
+
+// 修改所有子连接池的配置, 之后创建的子连接池也会使用修改后的配置, 参考 ConnectPool.UpdateConfig
+func (c *KeyedConnectPool) UpdateConfig(update func(conf *Config)) error {
+	c.mx.Lock()
+	if c.isClose() {
+		c.mx.Unlock()
+		return ErrPoolClosed
+	}
+	base, err := updatedConfig(c.base, update)
+	if err != nil {
+		c.mx.Unlock()
+		return err
+	}
+	c.base = base
+	c.mx.Unlock()
+
+	for _, e := range c.readyEntries() {
+		if err := e.pool.UpdateConfig(update); err != nil && err != ErrPoolClosed { // 期间被淘汰的子连接池忽略
+			return fmt.Errorf("key %s: %w", e.key, err)
+		}
+	}
+	return nil
+}
+
+// 预热key的子连接池, 子连接池不存在时会创建, 参考 ConnectPool.Warmup
+func (c *KeyedConnectPool) Warmup(ctx context.Context, key string, n int) error {
+	e, err := c.acquireEntry(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		c.mx.Lock()
+		e.active--
+		c.mx.Unlock()
+	}()
+	return e.pool.Warmup(ctx, n)
+}
+
+// 每个子连接池主动释放最多n个空闲conn, 返回释放的总数
+func (c *KeyedConnectPool) Shrink(n int) int {
+	shrink := 0
+	for _, e := range c.readyEntries() {
+		shrink += e.pool.Shrink(n)
+	}
+	return shrink
+}
+
+// 获取共享的活跃锁
+func (c *KeyedConnectPool) acquireActiveLock(ctx context.Context) error {
+	if c.activeLock == nil {
+		return nil
+	}
+
+	select {
+	case <-c.activeLock:
+		return nil
+	default:
+	}
+
+	c.mx.Lock()
+	clk, timeout := c.base.Clock, c.base.WaitTimeout
+	c.mx.Unlock()
+
+	t := clk.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-c.activeLock:
+		return nil
+	case <-c.close:
+		return ErrPoolClosed
+	case <-ctx.Done():
+		return ErrWaitGetConnTimeout
+	case <-t.C():
+		return ErrWaitGetConnTimeout
+	}
+}
+
+// 放回共享的活跃锁
+func (c *KeyedConnectPool) putActiveLock() {
+	if c.activeLock != nil {
+		c.activeLock <- struct{}{}
+	}
+}
+
+// 标记为已关闭, 如果之前已关闭返回false
+func (c *KeyedConnectPool) markClose() bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.isClose() {
+		return false
+	}
+	close(c.close)
+	return true
+}
+
+func (c *KeyedConnectPool) isClose() bool {
+	select {
+	case <-c.close:
+		return true
+	default:
+		return false
+	}
+}
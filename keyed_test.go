@@ -0,0 +1,255 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zlyuancn/connpool/clock"
+)
+
+type testKeyConn struct{ key string }
+
+func makeTestKeyedConfig() *KeyedConfig {
+	conf := NewKeyedConfig()
+	conf.MinIdle = 1
+	conf.ConnClose = testConnClose
+	conf.Creator = func(ctx context.Context, key string) (interface{}, error) {
+		return testKeyConn{key}, nil
+	}
+	return conf
+}
+
+func TestKeyedGetPut(t *testing.T) {
+	conf := makeTestKeyedConfig()
+	p, err := NewKeyedConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	a, err := p.Get(context.Background(), "a")
+	require.Nil(t, err)
+	require.Equal(t, testKeyConn{"a"}, a.GetConn())
+	b, err := p.Get(context.Background(), "b")
+	require.Nil(t, err)
+	require.Equal(t, testKeyConn{"b"}, b.GetConn())
+
+	key, ok := p.KeyOf(a)
+	require.True(t, ok)
+	require.Equal(t, "a", key)
+	require.Equal(t, []string{"b", "a"}, p.Keys())
+
+	require.Nil(t, p.Put(a))
+	require.Equal(t, ErrConnNotBorrowed, p.Put(a))
+	require.Nil(t, p.Discard(b, errors.New("broken")))
+	require.Equal(t, ErrConnNotOwned, p.Put(&Conn{}))
+
+	st := p.Stats()
+	require.Equal(t, 0, st.ActiveCount)
+	require.Equal(t, int64(2), st.GetCount)
+	require.Equal(t, int64(1), st.CloseCount[CloseReasonDiscard])
+	require.Len(t, p.KeyStats(), 2)
+}
+
+// 单个key和全局的活跃数限制
+func TestKeyedMaxActive(t *testing.T) {
+	conf := makeTestKeyedConfig()
+	conf.MaxActive = 2
+	conf.TotalMaxActive = 3
+	conf.WaitTimeout = time.Millisecond * 100
+	p, err := NewKeyedConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	var conns []*Conn
+	for i := 0; i < 2; i++ {
+		conn, err := p.Get(context.Background(), "a")
+		require.Nil(t, err)
+		conns = append(conns, conn)
+	}
+	_, err = p.Get(context.Background(), "a")
+	require.Equal(t, ErrWaitGetConnTimeout, err)
+
+	conn, err := p.Get(context.Background(), "b")
+	require.Nil(t, err)
+	conns = append(conns, conn)
+	_, err = p.Get(context.Background(), "c")
+	require.Equal(t, ErrWaitGetConnTimeout, err)
+
+	require.Nil(t, p.Put(conns[0]))
+	conn, err = p.Get(context.Background(), "c")
+	require.Nil(t, err)
+	require.Nil(t, p.Put(conn))
+	require.Equal(t, 3, p.Stats().MaxActive)
+}
+
+// 超过 MaxKeys 时淘汰最久未使用且没有活跃conn的子连接池
+func TestKeyedMaxKeys(t *testing.T) {
+	var mx sync.Mutex
+	var evicted []string
+	conf := makeTestKeyedConfig()
+	conf.MaxKeys = 2
+	conf.OnKeyEvict = func(key string) {
+		mx.Lock()
+		evicted = append(evicted, key)
+		mx.Unlock()
+	}
+	p, err := NewKeyedConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	a, err := p.Get(context.Background(), "a")
+	require.Nil(t, err)
+	b, err := p.Get(context.Background(), "b")
+	require.Nil(t, err)
+	require.Nil(t, p.Put(b))
+
+	// a有活跃conn, 淘汰b
+	c, err := p.Get(context.Background(), "c")
+	require.Nil(t, err)
+	require.Equal(t, []string{"c", "a"}, p.Keys())
+	require.Equal(t, []string{"b"}, evicted)
+
+	// 都有活跃conn时暂时超过 MaxKeys
+	d, err := p.Get(context.Background(), "d")
+	require.Nil(t, err)
+	require.Len(t, p.Keys(), 3)
+
+	for _, conn := range []*Conn{a, c, d} {
+		require.Nil(t, p.Put(conn))
+	}
+	require.Equal(t, int64(4), p.Stats().GetCount) // 包括已淘汰的子连接池
+}
+
+func TestKeyedIdleEvict(t *testing.T) {
+	fake := clock.NewFake(time.Time{})
+	conf := makeTestKeyedConfig()
+	conf.Clock = fake
+	conf.CheckIdleInterval = time.Second
+	conf.KeyIdleTimeout = time.Second * 10
+	evicted := make(chan string, 2)
+	conf.OnKeyEvict = func(key string) { evicted <- key }
+	p, err := NewKeyedConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	a, err := p.Get(context.Background(), "a")
+	require.Nil(t, err)
+	b, err := p.Get(context.Background(), "b")
+	require.Nil(t, err)
+	require.Nil(t, p.Put(b))
+
+	fake.Advance(time.Second * 10)
+	require.Equal(t, "b", <-evicted)
+	require.Equal(t, []string{"a"}, p.Keys()) // a有活跃conn, 不会被淘汰
+
+	// 淘汰后再次使用会重新创建
+	b, err = p.Get(context.Background(), "b")
+	require.Nil(t, err)
+	require.Equal(t, testKeyConn{"b"}, b.GetConn())
+	require.Nil(t, p.Put(a))
+	require.Nil(t, p.Put(b))
+}
+
+func TestKeyedCreateFail(t *testing.T) {
+	conf := makeTestKeyedConfig()
+	conf.WaitFirstConn = true
+	conf.Retry.MaxAttempts = 1
+	conf.Creator = func(ctx context.Context, key string) (interface{}, error) {
+		if key == "bad" {
+			return nil, errors.New("bad key")
+		}
+		return testKeyConn{key}, nil
+	}
+	p, err := NewKeyedConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	_, err = p.Get(context.Background(), "bad")
+	require.NotNil(t, err)
+	require.Empty(t, p.Keys())
+
+	conn, err := p.Get(context.Background(), "good")
+	require.Nil(t, err)
+	require.Nil(t, p.Put(conn))
+}
+
+func TestKeyedUpdateConfigAndShutdown(t *testing.T) {
+	conf := makeTestKeyedConfig()
+	p, err := NewKeyedConnectPool(conf)
+	require.Nil(t, err)
+
+	conn, err := p.Get(context.Background(), "a")
+	require.Nil(t, err)
+	require.Nil(t, p.UpdateConfig(func(conf *Config) { conf.MaxIdle = 7 }))
+	require.Equal(t, 7, p.KeyStats()["a"].MaxIdle)
+
+	// 之后创建的子连接池也使用新配置
+	require.Nil(t, p.Warmup(context.Background(), "b", 3))
+	require.Equal(t, 7, p.KeyStats()["b"].MaxIdle)
+	require.Eventually(t, func() bool { return p.KeyStats()["b"].IdleCount == 3 }, time.Second, time.Millisecond*10)
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		_ = p.Put(conn)
+	}()
+	report, err := p.Shutdown(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 0, report.Leaked)
+
+	_, err = p.Get(context.Background(), "a")
+	require.Equal(t, ErrPoolClosed, err)
+	require.Equal(t, ErrPoolClosed, p.UpdateConfig(func(conf *Config) {}))
+}
+
+// 子连接池回收泄漏的conn后释放共享的活跃锁
+func TestKeyedLeakReclaim(t *testing.T) {
+	fake := clock.NewFake(time.Time{})
+	conf := makeTestKeyedConfig()
+	conf.Clock = fake
+	conf.TotalMaxActive = 1
+	conf.CheckIdleInterval = time.Second
+	leaks := make(chan LeakInfo, 1)
+	conf.LeakDetection = LeakDetectionConfig{Threshold: time.Second, Reclaim: true, OnLeak: func(info LeakInfo) { leaks <- info }}
+	p, err := NewKeyedConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	leaked, err := p.Get(context.Background(), "a")
+	require.Nil(t, err)
+
+	fake.Advance(time.Second) // 触发子连接池的泄漏检查
+	require.True(t, (<-leaks).Reclaimed)
+
+	// 回收时已释放活跃锁, 不需要等待
+	conn, err := p.Get(context.Background(), "a")
+	require.Nil(t, err)
+	require.Nil(t, p.Put(conn))
+	require.Equal(t, ErrConnClosed, p.Put(leaked))
+}
+
+// 等待子连接池创建时ctx结束
+func TestKeyedCreateCtxCancel(t *testing.T) {
+	release := make(chan struct{})
+	conf := makeTestKeyedConfig()
+	conf.WaitFirstConn = true
+	conf.Creator = func(ctx context.Context, key string) (interface{}, error) {
+		<-release
+		return testKeyConn{key}, nil
+	}
+	p, err := NewKeyedConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err = p.Get(ctx, "a")
+	require.Equal(t, ErrWaitGetConnTimeout, err)
+
+	close(release)
+	conn, err := p.Get(context.Background(), "a")
+	require.Nil(t, err)
+	require.Nil(t, p.Put(conn))
+}
@@ -0,0 +1,105 @@
+package connpool
+
+import (
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// 泄漏检测配置
+type LeakDetectionConfig struct {
+	Threshold    time.Duration       // conn被取出超过该时间未放回视为泄漏, 小于1表示不启用泄漏检测
+	CaptureStack bool                // 是否记录取出conn时的调用栈
+	Reclaim      bool                // 是否回收泄漏的conn, 回收时会关闭conn并释放活跃计数, 之后放回该conn会被忽略
+	OnLeak       func(info LeakInfo) // 发现泄漏时的回调, 每个conn只会报告一次, 未设置时输出到标准库 log
+}
+
+// 泄漏的conn信息
+type LeakInfo struct {
+	Conn       *Conn
+	BorrowTime time.Time     // 取出时间
+	Held       time.Duration // 已持有时长
+	Stack      string        // 取出时的调用栈, 未开启 CaptureStack 时为空
+	Reclaimed  bool          // 是否已被回收
+}
+
+// conn的取出记录
+type borrowRecord struct {
+	t        time.Time
+	stack    []byte
+	reported bool
+}
+
+func defaultOnLeak(info LeakInfo) {
+	log.Printf("connpool: conn已取出%s未放回, reclaimed=%v\n%s", info.Held, info.Reclaimed, info.Stack)
+}
+
+// 记录conn被取出
+func (c *ConnectPool) trackBorrow(conn *Conn) {
+	if c.config().LeakDetection.Threshold < 1 {
+		return
+	}
+
+	record := &borrowRecord{t: c.now()}
+	if c.config().LeakDetection.CaptureStack {
+		record.stack = debug.Stack()
+	}
+
+	c.mx.Lock()
+	c.borrowed[conn] = record
+	c.mx.Unlock()
+}
+
+// 检查泄漏的conn
+func (c *ConnectPool) checkLeak() {
+	if c.config().LeakDetection.Threshold < 1 {
+		return
+	}
+
+	var leaks []LeakInfo
+	c.mx.Lock()
+	for conn, record := range c.borrowed {
+		held := c.since(record.t)
+		if held < c.config().LeakDetection.Threshold || record.reported {
+			continue
+		}
+		if conn.getState() != connStateBorrowed { // 正在放回或丢弃
+			continue
+		}
+
+		record.reported = true
+		info := LeakInfo{
+			Conn:       conn,
+			BorrowTime: record.t,
+			Held:       held,
+			Stack:      string(record.stack),
+		}
+		if c.config().LeakDetection.Reclaim && conn.casState(connStateBorrowed, connStateClosed) {
+			info.Reclaimed = true
+			c.reclaimConn(conn)
+		}
+		leaks = append(leaks, info)
+	}
+	c.mx.Unlock()
+
+	onLeak := c.config().LeakDetection.OnLeak
+	if onLeak == nil {
+		onLeak = defaultOnLeak
+	}
+	onReclaim := c.config().onReclaim
+	for _, info := range leaks {
+		if info.Reclaimed && onReclaim != nil {
+			onReclaim(info.Conn)
+		}
+		onLeak(info)
+	}
+}
+
+// 回收泄漏的conn, 需要加锁调用, 调用前conn已被标记为关闭
+func (c *ConnectPool) reclaimConn(conn *Conn) {
+	delete(c.borrowed, conn)
+	c.activeNum--
+	c.putActiveLock()
+	c.checkDrained()
+	go c.closeConn(conn, CloseReasonLeaked)
+}
@@ -0,0 +1,76 @@
+package connpool
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeakDetection(t *testing.T) {
+	var mx sync.Mutex
+	var leaks []LeakInfo
+
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.CheckIdleInterval = time.Millisecond * 300
+	conf.LeakDetection.Threshold = time.Millisecond * 200
+	conf.LeakDetection.CaptureStack = true
+	conf.LeakDetection.OnLeak = func(info LeakInfo) {
+		mx.Lock()
+		leaks = append(leaks, info)
+		mx.Unlock()
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	conn2, err := p.Get(context.Background())
+	require.Nil(t, err)
+	p.Put(conn2) // 及时放回的不会报告
+
+	time.Sleep(time.Millisecond * 700) // 等待触发两次检查, 只会报告一次
+	mx.Lock()
+	require.Equal(t, 1, len(leaks))
+	require.Equal(t, conn, leaks[0].Conn)
+	require.False(t, leaks[0].Reclaimed)
+	require.True(t, leaks[0].Held >= conf.LeakDetection.Threshold)
+	require.True(t, strings.Contains(leaks[0].Stack, "TestLeakDetection"))
+	mx.Unlock()
+
+	p.Put(conn)
+	require.Equal(t, 0, p.Stats().ActiveCount)
+}
+
+// 回收泄漏的conn
+func TestLeakReclaim(t *testing.T) {
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MaxActive = 1
+	conf.CheckIdleInterval = time.Millisecond * 300
+	conf.LeakDetection.Threshold = time.Millisecond * 200
+	conf.LeakDetection.Reclaim = true
+	conf.LeakDetection.OnLeak = func(info LeakInfo) {}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+
+	conn2, err := p.Get(context.Background()) // 回收后可以取到
+	require.Nil(t, err)
+	require.NotEqual(t, conn, conn2)
+
+	require.Equal(t, ErrConnClosed, p.Put(conn)) // 放回已回收的conn会被忽略
+	time.Sleep(time.Millisecond * 100)           // 关闭是通过goroutine的
+	st := p.Stats()
+	require.Equal(t, 1, st.ActiveCount)
+	require.Equal(t, int64(1), st.CloseCount[CloseReasonLeaked])
+	p.Put(conn2)
+}
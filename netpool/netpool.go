@@ -0,0 +1,182 @@
+// 基于连接池的 net.Conn, 关闭时自动放回连接池
+package netpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zlyuancn/connpool"
+)
+
+// 一个很早的时间, 设置为deadline可以立即中断正在进行的读写
+var aLongTimeAgo = time.Unix(1, 0)
+
+// 创建 net.Conn 的 connpool.Creator, 使用ctx控制拨号超时
+func Dial(network, address string) connpool.Creator {
+	var d net.Dialer
+	return func(ctx context.Context) (interface{}, error) {
+		return d.DialContext(ctx, network, address)
+	}
+}
+
+// 关闭 net.Conn 的 connpool.ConnClose
+func Close(conn *connpool.Conn) {
+	if c, ok := conn.GetConn().(net.Conn); ok {
+		_ = c.Close()
+	}
+}
+
+// 包装一个连接池, 其 Creator 创建的连接必须为 net.Conn
+type Pool struct {
+	pool connpool.IConnectPool
+}
+
+func Wrap(pool connpool.IConnectPool) *Pool {
+	return &Pool{pool: pool}
+}
+
+// 获取conn, 调用者使用完毕后必须调用 Close 将其放回
+//
+// ctx的deadline会被设置为socket的deadline, ctx结束时正在进行的读写会被中断.
+// 读写出现任何错误(包括超时)后, Close 会丢弃底层的conn而不是放回连接池.
+func (p *Pool) Get(ctx context.Context) (*Conn, error) {
+	raw, err := p.pool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nc, ok := raw.GetConn().(net.Conn)
+	if !ok {
+		err = fmt.Errorf("netpool: conn的类型为%T, 不是 net.Conn", raw.GetConn())
+		_ = p.pool.Discard(raw, err)
+		return nil, err
+	}
+
+	c := &Conn{Conn: nc, raw: raw, pool: p.pool}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := nc.SetDeadline(deadline); err != nil {
+			_ = p.pool.Discard(raw, err)
+			return nil, err
+		}
+	}
+	if done := ctx.Done(); done != nil {
+		c.stop = make(chan struct{})
+		c.stopped = make(chan struct{})
+		go c.watch(done)
+	}
+	return c, nil
+}
+
+// 获取底层的连接池
+func (p *Pool) Unwrap() connpool.IConnectPool {
+	return p.pool
+}
+
+// 从连接池取出的 net.Conn, Close 时放回连接池
+type Conn struct {
+	net.Conn
+	raw  *connpool.Conn
+	pool connpool.IConnectPool
+
+	stop    chan struct{} // 通知停止监听ctx, ctx不会结束时为nil
+	stopped chan struct{} // 已停止监听ctx
+
+	mx     sync.Mutex
+	err    error // 第一次读写失败的错误
+	closed int32 // 是否已关闭, atomic操作
+}
+
+// 监听ctx, 结束时中断正在进行的读写
+func (c *Conn) watch(done <-chan struct{}) {
+	defer close(c.stopped)
+	select {
+	case <-done:
+		_ = c.Conn.SetDeadline(aLongTimeAgo)
+	case <-c.stop:
+	}
+}
+
+// 记录读写错误
+func (c *Conn) setErr(err error) {
+	c.mx.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mx.Unlock()
+}
+
+// 获取第一次读写失败的错误, 没有失败时返回nil
+func (c *Conn) Err() error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return 0, net.ErrClosed
+	}
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.setErr(err)
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return 0, net.ErrClosed
+	}
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		c.setErr(err)
+	}
+	return n, err
+}
+
+// 将conn放回连接池, 读写失败过或无法清除deadline时丢弃. 重复关闭返回 net.ErrClosed
+func (c *Conn) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return net.ErrClosed
+	}
+
+	if c.stop != nil {
+		close(c.stop)
+		<-c.stopped
+	}
+
+	err := c.Err()
+	if err == nil {
+		err = c.Conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		return c.pool.Discard(c.raw, err)
+	}
+	return c.pool.Put(c.raw)
+}
+
+// 丢弃conn, 用于调用者发现conn已损坏时, 参考 connpool.IConnectPool
+func (c *Conn) Discard(reason error) error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return net.ErrClosed
+	}
+
+	if c.stop != nil {
+		close(c.stop)
+		<-c.stopped
+	}
+	if reason == nil {
+		reason = errors.New("netpool: 被调用者丢弃")
+	}
+	return c.pool.Discard(c.raw, reason)
+}
+
+// 获取原始的 connpool.Conn
+func (c *Conn) Raw() *connpool.Conn {
+	return c.raw
+}
@@ -0,0 +1,138 @@
+package netpool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zlyuancn/connpool"
+)
+
+// 通过 net.Pipe 创建conn, 保留服务端以便在测试中读写
+type testServer struct {
+	mx    sync.Mutex
+	peers map[net.Conn]net.Conn // 客户端 -> 服务端
+}
+
+func makeTestPool(t *testing.T) (*Pool, *testServer) {
+	s := &testServer{peers: make(map[net.Conn]net.Conn)}
+	conf := connpool.NewConfig()
+	conf.MinIdle = 1
+	conf.MaxIdle = 1
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		client, server := net.Pipe()
+		s.mx.Lock()
+		s.peers[client] = server
+		s.mx.Unlock()
+		return client, nil
+	}
+	conf.ConnClose = Close
+	p, err := connpool.NewConnectPool(conf)
+	require.Nil(t, err)
+	t.Cleanup(p.Close)
+	return Wrap(p), s
+}
+
+func (s *testServer) peer(c *Conn) net.Conn {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.peers[c.Conn]
+}
+
+func TestCloseReuse(t *testing.T) {
+	p, s := makeTestPool(t)
+
+	c, err := p.Get(context.Background())
+	require.Nil(t, err)
+	go func() { _, _ = s.peer(c).Write([]byte("hi")) }()
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(c, buf)
+	require.Nil(t, err)
+	require.Equal(t, "hi", string(buf))
+
+	raw := c.Raw()
+	require.Nil(t, c.Close())
+	require.Equal(t, net.ErrClosed, c.Close())
+	_, err = c.Read(buf)
+	require.Equal(t, net.ErrClosed, err)
+
+	c, err = p.Get(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, raw, c.Raw())
+	require.Nil(t, c.Close())
+	require.Equal(t, 0, p.Unwrap().Stats().ActiveCount)
+}
+
+// 读写失败后丢弃
+func TestCloseDiscardAfterErr(t *testing.T) {
+	p, s := makeTestPool(t)
+
+	c, err := p.Get(context.Background())
+	require.Nil(t, err)
+	_ = s.peer(c).Close()
+	_, err = c.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, io.EOF, c.Err())
+	require.Nil(t, c.Close())
+
+	st := p.Unwrap().Stats()
+	require.Equal(t, 0, st.ActiveCount)
+	require.Equal(t, int64(1), st.CloseCount[connpool.CloseReasonDiscard])
+}
+
+// ctx的deadline作为socket的deadline
+func TestCtxDeadline(t *testing.T) {
+	p, _ := makeTestPool(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	c, err := p.Get(ctx)
+	require.Nil(t, err)
+	_, err = c.Read(make([]byte, 1))
+	require.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	require.Nil(t, c.Close())
+	require.Equal(t, int64(1), p.Unwrap().Stats().CloseCount[connpool.CloseReasonDiscard])
+}
+
+// ctx被取消时中断读写
+func TestCtxCancel(t *testing.T) {
+	p, _ := makeTestPool(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := p.Get(ctx)
+	require.Nil(t, err)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		cancel()
+	}()
+	_, err = c.Read(make([]byte, 1))
+	require.NotNil(t, err)
+	require.Nil(t, c.Close())
+	require.Equal(t, int64(1), p.Unwrap().Stats().CloseCount[connpool.CloseReasonDiscard])
+}
+
+// 放回时清除deadline, 之后取出的conn不受之前ctx的影响
+func TestDeadlineReset(t *testing.T) {
+	p, s := makeTestPool(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	c, err := p.Get(ctx)
+	require.Nil(t, err)
+	require.Nil(t, c.Close())
+	cancel()
+	time.Sleep(time.Millisecond * 60)
+
+	c, err = p.Get(context.Background())
+	require.Nil(t, err)
+	go func() { _, _ = s.peer(c).Write([]byte("x")) }()
+	_, err = c.Read(make([]byte, 1))
+	require.Nil(t, err)
+	require.Nil(t, c.Discard(nil))
+	require.Equal(t, net.ErrClosed, c.Close())
+}
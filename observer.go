@@ -0,0 +1,144 @@
+package connpool
+
+import (
+	"context"
+	"time"
+)
+
+// 观察者, 用于观察conn的生命周期事件. 回调由连接池内部调用, 调用时不持有连接池的锁, 可以在回调中调用 Stats 等方法, 但不应长时间阻塞
+type Observer interface {
+	// 创建conn成功, cost为 Creator 耗时
+	OnCreate(conn *Conn, cost time.Duration)
+	// 创建conn失败, cost为 Creator 耗时
+	OnCreateError(err error, cost time.Duration)
+	// 获取conn成功
+	OnGet(ctx context.Context, conn *Conn, info GetInfo)
+	// 放回conn
+	OnPut(conn *Conn)
+	// 等待获取conn超时
+	OnWaitTimeout(ctx context.Context, info GetInfo)
+	// conn被连接池移除, 移除后不会再被使用
+	OnEvict(conn *Conn, reason CloseReason)
+	// 通过 ConnClose 关闭了conn, 校验失败被移除的conn不会调用 ConnClose
+	OnClose(conn *Conn, reason CloseReason)
+}
+
+// 可选的观察者接口, Observer 同时实现该接口时会收到调用者丢弃conn的事件
+type DiscardObserver interface {
+	// 调用者通过 Discard 丢弃了conn, reason为丢弃的原因
+	OnDiscard(conn *Conn, reason error)
+}
+
+// 可选的观察者接口, Observer 同时实现该接口时会收到获取conn失败的事件
+type GetErrorObserver interface {
+	// 获取conn失败, 包括等待超时, 连接池已关闭, 熔断以及等待数量超过限制等. info.Waited 为 Get 的耗时
+	OnGetError(ctx context.Context, err error, info GetInfo)
+}
+
+// 可选的观察者接口, Observer 同时实现该接口时会在获取conn开始和开始排队等待时收到事件
+type GetStartObserver interface {
+	// 开始获取conn, 返回的ctx会用于本次获取, 并传给之后的 OnWaitStart, OnGet, OnWaitTimeout 和 OnGetError
+	OnGetStart(ctx context.Context) context.Context
+	// 开始排队等待conn, queuePosition为在等待队列中的位置, 从1开始
+	OnWaitStart(ctx context.Context, queuePosition int)
+}
+
+// 获取conn的信息
+type GetInfo struct {
+	Waited        time.Duration // 等待时间, 未等待时为0
+	QueuePosition int           // 开始等待时在等待队列中的位置, 从1开始, 未等待时为0
+	Fresh         bool          // 是否为新创建的conn, 即第一次被取出
+}
+
+// 空的观察者, 可以嵌入到自定义观察者中, 只实现关心的方法
+type NopObserver struct{}
+
+func (NopObserver) OnCreate(conn *Conn, cost time.Duration)             {}
+func (NopObserver) OnCreateError(err error, cost time.Duration)         {}
+func (NopObserver) OnGet(ctx context.Context, conn *Conn, info GetInfo) {}
+func (NopObserver) OnPut(conn *Conn)                                    {}
+func (NopObserver) OnWaitTimeout(ctx context.Context, info GetInfo)     {}
+func (NopObserver) OnEvict(conn *Conn, reason CloseReason)              {}
+func (NopObserver) OnClose(conn *Conn, reason CloseReason)              {}
+
+// 将多个观察者组合为一个, 按顺序依次调用
+func MultiObserver(observers ...Observer) Observer {
+	return multiObserver(observers)
+}
+
+type multiObserver []Observer
+
+func (m multiObserver) OnCreate(conn *Conn, cost time.Duration) {
+	for _, o := range m {
+		o.OnCreate(conn, cost)
+	}
+}
+
+func (m multiObserver) OnCreateError(err error, cost time.Duration) {
+	for _, o := range m {
+		o.OnCreateError(err, cost)
+	}
+}
+
+func (m multiObserver) OnGet(ctx context.Context, conn *Conn, info GetInfo) {
+	for _, o := range m {
+		o.OnGet(ctx, conn, info)
+	}
+}
+
+func (m multiObserver) OnPut(conn *Conn) {
+	for _, o := range m {
+		o.OnPut(conn)
+	}
+}
+
+func (m multiObserver) OnWaitTimeout(ctx context.Context, info GetInfo) {
+	for _, o := range m {
+		o.OnWaitTimeout(ctx, info)
+	}
+}
+
+func (m multiObserver) OnDiscard(conn *Conn, reason error) {
+	for _, o := range m {
+		if d, ok := o.(DiscardObserver); ok {
+			d.OnDiscard(conn, reason)
+		}
+	}
+}
+
+func (m multiObserver) OnGetError(ctx context.Context, err error, info GetInfo) {
+	for _, o := range m {
+		if g, ok := o.(GetErrorObserver); ok {
+			g.OnGetError(ctx, err, info)
+		}
+	}
+}
+
+func (m multiObserver) OnGetStart(ctx context.Context) context.Context {
+	for _, o := range m {
+		if g, ok := o.(GetStartObserver); ok {
+			ctx = g.OnGetStart(ctx)
+		}
+	}
+	return ctx
+}
+
+func (m multiObserver) OnWaitStart(ctx context.Context, queuePosition int) {
+	for _, o := range m {
+		if g, ok := o.(GetStartObserver); ok {
+			g.OnWaitStart(ctx, queuePosition)
+		}
+	}
+}
+
+func (m multiObserver) OnEvict(conn *Conn, reason CloseReason) {
+	for _, o := range m {
+		o.OnEvict(conn, reason)
+	}
+}
+
+func (m multiObserver) OnClose(conn *Conn, reason CloseReason) {
+	for _, o := range m {
+		o.OnClose(conn, reason)
+	}
+}
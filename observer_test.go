@@ -0,0 +1,272 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testObserver struct {
+	NopObserver
+	mx          sync.Mutex
+	create      int
+	createErr   int
+	get         []GetInfo
+	put         int
+	waitTimeout int
+	getErr      []error
+	evict       map[CloseReason]int
+	close       map[CloseReason]int
+}
+
+func newTestObserver() *testObserver {
+	return &testObserver{
+		evict: make(map[CloseReason]int),
+		close: make(map[CloseReason]int),
+	}
+}
+
+func (o *testObserver) OnCreate(conn *Conn, cost time.Duration) {
+	o.mx.Lock()
+	o.create++
+	o.mx.Unlock()
+}
+
+func (o *testObserver) OnCreateError(err error, cost time.Duration) {
+	o.mx.Lock()
+	o.createErr++
+	o.mx.Unlock()
+}
+
+func (o *testObserver) OnGet(ctx context.Context, conn *Conn, info GetInfo) {
+	o.mx.Lock()
+	o.get = append(o.get, info)
+	o.mx.Unlock()
+}
+
+func (o *testObserver) OnPut(conn *Conn) {
+	o.mx.Lock()
+	o.put++
+	o.mx.Unlock()
+}
+
+func (o *testObserver) OnWaitTimeout(ctx context.Context, info GetInfo) {
+	o.mx.Lock()
+	o.waitTimeout++
+	o.mx.Unlock()
+}
+
+func (o *testObserver) OnGetError(ctx context.Context, err error, info GetInfo) {
+	o.mx.Lock()
+	o.getErr = append(o.getErr, err)
+	o.mx.Unlock()
+}
+
+func (o *testObserver) OnEvict(conn *Conn, reason CloseReason) {
+	o.mx.Lock()
+	o.evict[reason]++
+	o.mx.Unlock()
+}
+
+func (o *testObserver) OnClose(conn *Conn, reason CloseReason) {
+	o.mx.Lock()
+	o.close[reason]++
+	o.mx.Unlock()
+}
+
+func TestObserver(t *testing.T) {
+	obs := newTestObserver()
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MinIdle = 1
+	conf.MaxActive = 1
+	conf.WaitTimeout = time.Millisecond * 100
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	conf.Observer = obs
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	_, err = p.Get(context.Background()) // 等待超时
+	require.Equal(t, ErrWaitGetConnTimeout, err)
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		p.Put(conn)
+	}()
+	conn, err = p.Get(context.Background()) // 等待放回
+	require.Nil(t, err)
+	p.Put(conn)
+	p.Close()
+
+	obs.mx.Lock()
+	defer obs.mx.Unlock()
+	require.Equal(t, 1, obs.create)
+	require.Equal(t, 2, len(obs.get))
+	require.Equal(t, GetInfo{Fresh: true}, obs.get[0])
+	require.Equal(t, 1, obs.get[1].QueuePosition)
+	require.True(t, obs.get[1].Waited > 0)
+	require.False(t, obs.get[1].Fresh) // 放回的conn
+	require.Equal(t, 2, obs.put)
+	require.Equal(t, 1, obs.waitTimeout)
+	require.Equal(t, 1, obs.evict[CloseReasonPoolClosed])
+	require.Equal(t, 1, obs.close[CloseReasonPoolClosed])
+}
+
+func TestObserverInvalidAndCreateErr(t *testing.T) {
+	obs1, obs2 := newTestObserver(), newTestObserver()
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MinIdle = 1
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	conf.WaitTimeout = time.Millisecond * 100
+	conf.Retry.MaxAttempts = 1
+	conf.Observer = MultiObserver(obs1, obs2)
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conf.ValidConnected = func(conn *Conn) bool { return false }
+	conf.Creator = func(ctx context.Context) (interface{}, error) { return nil, errors.New("") }
+	_, err = p.Get(context.Background())
+	require.NotNil(t, err)
+	p.Close() // 等待异步的移除完成
+
+	for _, obs := range []*testObserver{obs1, obs2} {
+		obs.mx.Lock()
+		require.Equal(t, 1, obs.evict[CloseReasonInvalid])
+		require.Equal(t, 0, obs.close[CloseReasonInvalid]) // 校验失败的conn不会关闭
+		require.True(t, obs.createErr > 0)
+		obs.mx.Unlock()
+	}
+}
+
+// 获取失败时通知 GetErrorObserver
+func TestObserverGetError(t *testing.T) {
+	obs := newTestObserver()
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MaxActive = 1
+	conf.WaitTimeout = time.Millisecond * 50
+	conf.Observer = MultiObserver(NopObserver{}, obs)
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	_, err = p.Get(context.Background())
+	require.Equal(t, ErrWaitGetConnTimeout, err)
+	require.Nil(t, p.Put(conn))
+	p.Close()
+	_, err = p.Get(context.Background())
+	require.Equal(t, ErrPoolClosed, err)
+
+	obs.mx.Lock()
+	defer obs.mx.Unlock()
+	require.Equal(t, []error{ErrWaitGetConnTimeout, ErrPoolClosed}, obs.getErr)
+	require.Equal(t, 1, obs.waitTimeout)
+}
+
+type statsObserver struct {
+	NopObserver
+	pool  IConnectPool
+	evict chan CloseReason
+}
+
+func (o *statsObserver) OnEvict(conn *Conn, reason CloseReason) {
+	_ = o.pool.Stats() // 持有连接池的锁时调用会死锁
+	o.evict <- reason
+}
+
+// 观察者回调中可以调用连接池的方法
+func TestObserverCallPool(t *testing.T) {
+	obs := &statsObserver{evict: make(chan CloseReason, 4)}
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MinIdle = 1
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	conf.Observer = obs
+	invalid := int32(0)
+	conf.ValidConnected = func(conn *Conn) bool { return !atomic.CompareAndSwapInt32(&invalid, 1, 0) }
+	pool, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	obs.pool = pool
+	defer pool.Close()
+
+	// 取出时空闲的conn校验失败
+	atomic.StoreInt32(&invalid, 1)
+	conn, err := pool.Get(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, CloseReasonInvalid, <-obs.evict)
+
+	// 放回时连接池已关闭
+	pool.Close()
+	require.Nil(t, pool.Put(conn))
+	for reason := range obs.evict {
+		if reason == CloseReasonPoolClosed {
+			break
+		}
+	}
+}
+
+type getStartKey struct{}
+
+type getStartObserver struct {
+	NopObserver
+	mx        sync.Mutex
+	waitStart []int
+	got       []interface{}
+}
+
+func (o *getStartObserver) OnGetStart(ctx context.Context) context.Context {
+	return context.WithValue(ctx, getStartKey{}, "get")
+}
+
+func (o *getStartObserver) OnWaitStart(ctx context.Context, queuePosition int) {
+	o.mx.Lock()
+	o.waitStart = append(o.waitStart, queuePosition)
+	o.mx.Unlock()
+}
+
+func (o *getStartObserver) OnGet(ctx context.Context, conn *Conn, info GetInfo) {
+	o.mx.Lock()
+	o.got = append(o.got, ctx.Value(getStartKey{}))
+	o.mx.Unlock()
+}
+
+// OnGetStart 返回的ctx用于之后的事件, 排队等待时通知 OnWaitStart
+func TestObserverGetStart(t *testing.T) {
+	obs := &getStartObserver{}
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MaxActive = 1
+	conf.Observer = MultiObserver(NopObserver{}, obs)
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	got := make(chan error, 1)
+	go func() {
+		conn, err := p.Get(context.Background())
+		if err == nil {
+			err = p.Put(conn)
+		}
+		got <- err
+	}()
+	require.Eventually(t, func() bool { return p.Stats().WaitQueueLen == 1 }, time.Second, time.Millisecond*10)
+	require.Nil(t, p.Put(conn))
+	require.Nil(t, <-got)
+
+	obs.mx.Lock()
+	defer obs.mx.Unlock()
+	require.Equal(t, []int{1}, obs.waitStart)
+	require.Equal(t, []interface{}{"get", "get"}, obs.got)
+}
@@ -0,0 +1,292 @@
+// Package otel 提供连接池的 OpenTelemetry 链路追踪和指标
+//
+// 指标遵循 OpenTelemetry 数据库客户端连接池语义约定 (db.client.connection.*).
+package otel
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zlyuancn/connpool"
+	"github.com/zlyuancn/connpool/clock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/zlyuancn/connpool/otel"
+
+const (
+	// 连接池名
+	AttrPoolName = attribute.Key("db.client.connection.pool.name")
+	// conn状态, idle 或 used
+	AttrState = attribute.Key("db.client.connection.state")
+	// 获取conn的等待时间, 单位秒
+	AttrWaited = attribute.Key("connpool.waited")
+	// 开始等待时在等待队列中的位置
+	AttrQueuePosition = attribute.Key("connpool.queue_position")
+	// 是否获取到新创建的conn
+	AttrFresh = attribute.Key("connpool.fresh")
+)
+
+type options struct {
+	tp trace.TracerProvider
+	mp metric.MeterProvider
+}
+
+type Option func(o *options)
+
+// 设置 TracerProvider, 默认使用全局的
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) { o.tp = tp }
+}
+
+// 设置 MeterProvider, 默认使用全局的
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(o *options) { o.mp = mp }
+}
+
+// 连接池的链路追踪和指标
+//
+// 通过 Instrument 设置到连接池配置中, 创建连接池后通过 SetPool 绑定连接池以输出连接池状态指标.
+// 需要在设置 Creator 和 Clock 之后调用 Instrument.
+//
+//	inst, _ := otel.Instrument("redis", conf)
+//	pool, _ := connpool.NewConnectPool(conf)
+//	inst.SetPool(pool)
+type Instrumentation struct {
+	connpool.NopObserver
+
+	name   string
+	attrs  attribute.Set
+	tracer trace.Tracer
+	clock  clock.Clock
+
+	mx   sync.RWMutex
+	pool connpool.IConnectPool
+
+	waitMx  sync.Mutex
+	waiting map[trace.Span]struct{} // 正在排队等待的获取span
+
+	timeouts   metric.Int64Counter
+	createTime metric.Float64Histogram
+	waitTime   metric.Float64Histogram
+}
+
+// 为连接池配置添加链路追踪和指标, 会与配置中已有的 Observer 组合
+//
+// 会包装配置中的 Creator, 创建conn的span以传入 Creator 的ctx为父span, Creator 内的拨号等span是它的子span.
+// 自动补充conn时没有父span, 创建span会链接到开始创建时正在排队等待的获取span
+func Instrument(poolName string, conf *connpool.Config, opts ...Option) (*Instrumentation, error) {
+	o := &options{}
+	for _, fn := range opts {
+		fn(o)
+	}
+	if o.tp == nil {
+		o.tp = otel.GetTracerProvider()
+	}
+	if o.mp == nil {
+		o.mp = otel.GetMeterProvider()
+	}
+
+	inst := &Instrumentation{
+		name:    poolName,
+		attrs:   attribute.NewSet(AttrPoolName.String(poolName)),
+		tracer:  o.tp.Tracer(instrumentationName),
+		clock:   conf.Clock,
+		waiting: make(map[trace.Span]struct{}),
+	}
+	if inst.clock == nil {
+		inst.clock = clock.Real()
+	}
+	if err := inst.initMetrics(o.mp.Meter(instrumentationName)); err != nil {
+		return nil, err
+	}
+
+	if creator := conf.Creator; creator != nil {
+		conf.Creator = func(ctx context.Context) (interface{}, error) {
+			ctx, span := inst.tracer.Start(ctx, "connpool.create",
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithTimestamp(inst.clock.Now()),
+				trace.WithAttributes(AttrPoolName.String(poolName)),
+				trace.WithLinks(inst.waitingLinks()...),
+			)
+			v, err := creator(ctx)
+			endSpan(span, inst.clock.Now(), err)
+			return v, err
+		}
+	}
+
+	if conf.Observer != nil {
+		conf.Observer = connpool.MultiObserver(conf.Observer, inst)
+	} else {
+		conf.Observer = inst
+	}
+	return inst, nil
+}
+
+func (i *Instrumentation) initMetrics(meter metric.Meter) error {
+	var err error
+	i.timeouts, err = meter.Int64Counter("db.client.connection.timeouts",
+		metric.WithUnit("{timeout}"),
+		metric.WithDescription("等待获取conn超时次数"))
+	if err != nil {
+		return err
+	}
+	i.createTime, err = meter.Float64Histogram("db.client.connection.create_time",
+		metric.WithUnit("s"),
+		metric.WithDescription("创建conn的耗时"))
+	if err != nil {
+		return err
+	}
+	i.waitTime, err = meter.Float64Histogram("db.client.connection.wait_time",
+		metric.WithUnit("s"),
+		metric.WithDescription("获取conn的等待时间"))
+	if err != nil {
+		return err
+	}
+
+	count, err := meter.Int64ObservableUpDownCounter("db.client.connection.count",
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("当前conn数量"))
+	if err != nil {
+		return err
+	}
+	idleMax, err := meter.Int64ObservableUpDownCounter("db.client.connection.idle.max",
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("最大闲置conn数量"))
+	if err != nil {
+		return err
+	}
+	idleMin, err := meter.Int64ObservableUpDownCounter("db.client.connection.idle.min",
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("最小闲置conn数量"))
+	if err != nil {
+		return err
+	}
+	max, err := meter.Int64ObservableUpDownCounter("db.client.connection.max",
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("最大活跃conn数量"))
+	if err != nil {
+		return err
+	}
+	pending, err := meter.Int64ObservableUpDownCounter("db.client.connection.pending_requests",
+		metric.WithUnit("{request}"),
+		metric.WithDescription("等待获取conn的请求数量"))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		i.mx.RLock()
+		pool := i.pool
+		i.mx.RUnlock()
+		if pool == nil {
+			return nil
+		}
+
+		st := pool.Stats()
+		attrs := metric.WithAttributeSet(i.attrs)
+		o.ObserveInt64(count, int64(st.IdleCount), metric.WithAttributes(AttrPoolName.String(i.name), AttrState.String("idle")))
+		o.ObserveInt64(count, int64(st.ActiveCount), metric.WithAttributes(AttrPoolName.String(i.name), AttrState.String("used")))
+		o.ObserveInt64(idleMax, int64(st.MaxIdle), attrs)
+		o.ObserveInt64(idleMin, int64(st.MinIdle), attrs)
+		o.ObserveInt64(max, int64(st.MaxActive), attrs)
+		o.ObserveInt64(pending, int64(st.WaitQueueLen+st.ActiveWaitQueueLen), attrs)
+		return nil
+	}, count, idleMax, idleMin, max, pending)
+	return err
+}
+
+// 绑定连接池, 绑定后才会输出连接池状态相关的指标
+func (i *Instrumentation) SetPool(pool connpool.IConnectPool) {
+	i.mx.Lock()
+	i.pool = pool
+	i.mx.Unlock()
+}
+
+func (i *Instrumentation) OnCreate(conn *connpool.Conn, cost time.Duration) {
+	i.createTime.Record(context.Background(), cost.Seconds(), metric.WithAttributeSet(i.attrs))
+}
+
+func (i *Instrumentation) OnCreateError(err error, cost time.Duration) {
+	i.createTime.Record(context.Background(), cost.Seconds(), metric.WithAttributeSet(i.attrs))
+}
+
+// 开始获取时创建span, 排队等待的时间包含在span内
+func (i *Instrumentation) OnGetStart(ctx context.Context) context.Context {
+	ctx, span := i.tracer.Start(ctx, "connpool.get",
+		trace.WithTimestamp(i.clock.Now()),
+		trace.WithAttributes(AttrPoolName.String(i.name)),
+	)
+	return context.WithValue(ctx, getSpanKey{}, span)
+}
+
+func (i *Instrumentation) OnWaitStart(ctx context.Context, queuePosition int) {
+	span, ok := ctx.Value(getSpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	i.waitMx.Lock()
+	i.waiting[span] = struct{}{}
+	i.waitMx.Unlock()
+}
+
+func (i *Instrumentation) OnGet(ctx context.Context, conn *connpool.Conn, info connpool.GetInfo) {
+	i.waitTime.Record(ctx, info.Waited.Seconds(), metric.WithAttributeSet(i.attrs))
+	i.endGetSpan(ctx, info, nil)
+}
+
+func (i *Instrumentation) OnWaitTimeout(ctx context.Context, info connpool.GetInfo) {
+	i.waitTime.Record(ctx, info.Waited.Seconds(), metric.WithAttributeSet(i.attrs))
+	i.timeouts.Add(ctx, 1, metric.WithAttributeSet(i.attrs))
+}
+
+// 获取失败时span带错误状态, 包括等待超时, 连接池已关闭, 熔断等
+func (i *Instrumentation) OnGetError(ctx context.Context, err error, info connpool.GetInfo) {
+	i.endGetSpan(ctx, info, err)
+}
+
+type getSpanKey struct{}
+
+// 结束 OnGetStart 创建的span
+func (i *Instrumentation) endGetSpan(ctx context.Context, info connpool.GetInfo, err error) {
+	span, ok := ctx.Value(getSpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	i.waitMx.Lock()
+	delete(i.waiting, span)
+	i.waitMx.Unlock()
+
+	span.SetAttributes(
+		AttrWaited.Float64(info.Waited.Seconds()),
+		AttrQueuePosition.Int(info.QueuePosition),
+		AttrFresh.Bool(info.Fresh),
+	)
+	endSpan(span, i.clock.Now(), err)
+}
+
+// 正在排队等待的获取span的链接
+func (i *Instrumentation) waitingLinks() []trace.Link {
+	i.waitMx.Lock()
+	defer i.waitMx.Unlock()
+
+	links := make([]trace.Link, 0, len(i.waiting))
+	for span := range i.waiting {
+		links = append(links, trace.Link{SpanContext: span.SpanContext()})
+	}
+	return links
+}
+
+// 结束span, err不为nil时记录错误状态
+func endSpan(span trace.Span, end time.Time, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
@@ -0,0 +1,202 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zlyuancn/connpool"
+	"github.com/zlyuancn/connpool/clock"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func makeTestConfig() *connpool.Config {
+	conf := connpool.NewConfig()
+	conf.WaitFirstConn = true
+	conf.MinIdle = 1
+	conf.MaxActive = 1
+	conf.WaitTimeout = time.Millisecond * 100
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	conf.Creator = func(ctx context.Context) (interface{}, error) { return struct{}{}, nil }
+	conf.ConnClose = func(conn *connpool.Conn) {}
+	return conf
+}
+
+func TestInstrument(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	conf := makeTestConfig()
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		_, dial := tp.Tracer("test").Start(ctx, "dial")
+		dial.End()
+		return struct{}{}, nil
+	}
+	inst, err := Instrument("test", conf, WithTracerProvider(tp), WithMeterProvider(mp))
+	require.Nil(t, err)
+	pool, err := connpool.NewConnectPool(conf)
+	require.Nil(t, err)
+	inst.SetPool(pool)
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	conn, err := pool.Get(ctx)
+	require.Nil(t, err)
+	_, err = pool.Get(ctx) // 等待超时
+	require.True(t, errors.Is(err, connpool.ErrWaitGetConnTimeout))
+	pool.Put(conn)
+	pool.Close()
+	_, err = pool.Get(ctx) // 连接池已关闭
+	require.Equal(t, connpool.ErrPoolClosed, err)
+	parent.End()
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range sr.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	require.Equal(t, 1, len(spans["connpool.create"]))
+	require.False(t, spans["connpool.create"][0].Parent().IsValid())
+	require.Equal(t, spans["connpool.create"][0].SpanContext().SpanID(), spans["dial"][0].Parent().SpanID()) // 拨号是创建的子span
+	require.Equal(t, 3, len(spans["connpool.get"]))
+	for _, span := range spans["connpool.get"] {
+		require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+	require.Equal(t, codes.Unset, spans["connpool.get"][0].Status().Code)
+	timeoutSpan := spans["connpool.get"][1]
+	require.Equal(t, codes.Error, timeoutSpan.Status().Code)
+	require.True(t, timeoutSpan.EndTime().Sub(timeoutSpan.StartTime()) >= conf.WaitTimeout)
+	require.Equal(t, codes.Error, spans["connpool.get"][2].Status().Code)
+	require.Equal(t, connpool.ErrPoolClosed.Error(), spans["connpool.get"][2].Status().Description)
+
+	var rm metricdata.ResourceMetrics
+	require.Nil(t, reader.Collect(context.Background(), &rm))
+	metrics := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	timeouts := metrics["db.client.connection.timeouts"].(metricdata.Sum[int64])
+	require.Equal(t, int64(1), timeouts.DataPoints[0].Value)
+	count := metrics["db.client.connection.count"].(metricdata.Sum[int64])
+	require.Equal(t, 2, len(count.DataPoints)) // idle 和 used
+	maxActive := metrics["db.client.connection.max"].(metricdata.Sum[int64])
+	require.Equal(t, int64(1), maxActive.DataPoints[0].Value)
+	waitTime := metrics["db.client.connection.wait_time"].(metricdata.Histogram[float64])
+	require.Equal(t, uint64(2), waitTime.DataPoints[0].Count)
+}
+
+// 与已有的 Observer 组合
+func TestInstrumentMultiObserver(t *testing.T) {
+	conf := makeTestConfig()
+	obs := &countObserver{}
+	conf.Observer = obs
+	_, err := Instrument("test", conf)
+	require.Nil(t, err)
+
+	pool, err := connpool.NewConnectPool(conf)
+	require.Nil(t, err)
+	pool.Close()
+	require.Equal(t, 1, obs.create)
+}
+
+type countObserver struct {
+	connpool.NopObserver
+	create int
+}
+
+func (o *countObserver) OnCreate(conn *connpool.Conn, cost time.Duration) {
+	o.create++
+}
+
+// span的时间来自连接池的时钟
+func TestInstrumentClock(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	conf := makeTestConfig()
+	conf.Clock = fake
+	_, err := Instrument("test", conf, WithTracerProvider(tp))
+	require.Nil(t, err)
+	pool, err := connpool.NewConnectPool(conf)
+	require.Nil(t, err)
+	defer pool.Close()
+
+	conn, err := pool.Get(context.Background())
+	require.Nil(t, err)
+	require.Nil(t, pool.Put(conn))
+
+	require.Len(t, sr.Ended(), 2)
+	for _, span := range sr.Ended() {
+		require.Equal(t, start, span.StartTime())
+		require.Equal(t, start, span.EndTime())
+	}
+}
+
+// 获取span从开始等待前开始, 自动创建的span链接到等待中的获取span
+func TestInstrumentWaitLink(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+	createNum := int32(0)
+	conf := makeTestConfig()
+	conf.Clock = fake
+	conf.MaxActive = 2
+	conf.BatchIncrement = 1
+	conf.WaitTimeout = time.Minute
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt32(&createNum, 1) > 1 { // 初始的conn立即创建
+			started <- struct{}{}
+			<-release
+		}
+		return struct{}{}, nil
+	}
+	_, err := Instrument("test", conf, WithTracerProvider(tp))
+	require.Nil(t, err)
+	pool, err := connpool.NewConnectPool(conf)
+	require.Nil(t, err)
+	defer pool.Close()
+
+	_, err = pool.Get(context.Background()) // 取走初始的conn
+	require.Nil(t, err)
+	got := make(chan error, 1)
+	go func() {
+		_, err := pool.Get(context.Background())
+		got <- err
+	}()
+	<-started
+	fake.Advance(time.Second)
+	close(release)
+	require.Nil(t, <-got)
+
+	var waitGet sdktrace.ReadOnlySpan
+	var links []sdktrace.Link
+	for _, span := range sr.Ended() {
+		switch span.Name() {
+		case "connpool.get":
+			waitGet = span // 第二次获取的span最后结束
+		case "connpool.create":
+			links = append(links, span.Links()...)
+		}
+	}
+	require.Equal(t, start, waitGet.StartTime())
+	require.Equal(t, start.Add(time.Second), waitGet.EndTime())
+	require.NotEmpty(t, links)
+	for _, link := range links {
+		require.Equal(t, waitGet.SpanContext(), link.SpanContext)
+	}
+}
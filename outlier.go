@@ -0,0 +1,227 @@
+package connpool
+
+import (
+	"time"
+)
+
+const (
+	// 错误率统计窗口
+	defOutlierErrorRateWindow = time.Second * 10
+	// 错误率统计窗口内的最少结果数
+	defOutlierErrorRateMinRequests = 5
+	// 基础驱逐时间
+	defOutlierBaseEjectionTime = time.Second * 30
+	// 最大驱逐时间
+	defOutlierMaxEjectionTime = time.Minute * 5
+	// 最多驱逐的节点比例
+	defOutlierMaxEjectionPercent = 50
+	// 重新接纳前需要连续探测成功的次数
+	defOutlierProbeSuccesses = 2
+)
+
+// 异常节点驱逐配置, 作用于 ClusterPool
+//
+// 节点的 Creator 结果, HealthChecker 结果以及 ValidConnected 的失败会被统计, 达到阈值后节点被驱逐,
+// 驱逐期间 Get 不会选择该节点, 到期后在后台创建conn进行探测, 连续成功 ProbeSuccesses 次后重新接纳.
+// 第n次驱逐的时间为 BaseEjectionTime * 2^(n-1), 不超过 MaxEjectionTime.
+type OutlierConfig struct {
+	ConsecutiveErrors    int           // 连续失败多少次后驱逐, 小于1表示不按连续失败驱逐
+	ErrorRateThreshold   float64       // 错误率阈值, 取值0~1, 小于等于0表示不按错误率驱逐
+	ErrorRateWindow      time.Duration // 错误率统计窗口
+	ErrorRateMinRequests int           // 统计窗口内结果数达到该值才会按错误率驱逐
+	BaseEjectionTime     time.Duration // 基础驱逐时间
+	MaxEjectionTime      time.Duration // 最大驱逐时间, 重新接纳后超过该时间未被驱逐会重置驱逐次数
+	MaxEjectionPercent   int           // 最多驱逐的节点比例, 取值1~100, 至少允许驱逐一个节点
+	ProbeSuccesses       int           // 重新接纳前需要连续探测成功的次数
+
+	OnEject   func(addr string, ejectionTime time.Duration) // 节点被驱逐时的回调
+	OnReadmit func(addr string)                             // 节点被重新接纳时的回调
+}
+
+func (o *OutlierConfig) check() {
+	if o.ErrorRateWindow < 1 {
+		o.ErrorRateWindow = defOutlierErrorRateWindow
+	}
+	if o.ErrorRateMinRequests < 1 {
+		o.ErrorRateMinRequests = defOutlierErrorRateMinRequests
+	}
+	if o.BaseEjectionTime < 1 {
+		o.BaseEjectionTime = defOutlierBaseEjectionTime
+	}
+	if o.MaxEjectionTime < o.BaseEjectionTime {
+		o.MaxEjectionTime = defOutlierMaxEjectionTime
+		if o.MaxEjectionTime < o.BaseEjectionTime {
+			o.MaxEjectionTime = o.BaseEjectionTime
+		}
+	}
+	if o.MaxEjectionPercent < 1 || o.MaxEjectionPercent > 100 {
+		o.MaxEjectionPercent = defOutlierMaxEjectionPercent
+	}
+	if o.ProbeSuccesses < 1 {
+		o.ProbeSuccesses = defOutlierProbeSuccesses
+	}
+}
+
+// 是否启用驱逐
+func (o *OutlierConfig) enabled() bool {
+	return o.ConsecutiveErrors > 0 || o.ErrorRateThreshold > 0
+}
+
+// 第n次驱逐的驱逐时间
+func (o *OutlierConfig) ejectionTime(n int) time.Duration {
+	d := o.BaseEjectionTime
+	for i := 1; i < n && d < o.MaxEjectionTime; i++ {
+		d *= 2
+	}
+	if d > o.MaxEjectionTime {
+		d = o.MaxEjectionTime
+	}
+	return d
+}
+
+// 节点的驱逐状态, 由 ClusterPool.mx 保护
+type outlierState struct {
+	consecutive int       // 连续失败次数
+	windowStart time.Time // 错误率统计窗口开始时间
+	total       int       // 窗口内的结果数
+	errors      int       // 窗口内的失败数
+
+	ejected     bool      // 是否已被驱逐
+	probing     bool      // 是否正在探测
+	ejectUntil  time.Time // 驱逐到期时间
+	ejectCount  int       // 驱逐次数
+	readmitTime time.Time // 最后一次重新接纳的时间
+}
+
+// 记录节点的一次结果, 达到阈值时驱逐节点
+func (c *ClusterPool) recordResult(ep *clusterEndpoint, ok bool) {
+	conf := &c.conf.Outlier
+	if !conf.enabled() {
+		return
+	}
+
+	c.mx.Lock()
+	o := &ep.outlier
+	if o.ejected { // 驱逐期间由探测决定是否重新接纳
+		c.mx.Unlock()
+		return
+	}
+
+	now := c.conf.Clock.Now()
+	if now.Sub(o.windowStart) >= conf.ErrorRateWindow {
+		o.windowStart = now
+		o.total, o.errors = 0, 0
+	}
+	o.total++
+	if ok {
+		o.consecutive = 0
+		c.mx.Unlock()
+		return
+	}
+	o.errors++
+	o.consecutive++
+
+	need := conf.ConsecutiveErrors > 0 && o.consecutive >= conf.ConsecutiveErrors ||
+		conf.ErrorRateThreshold > 0 && o.total >= conf.ErrorRateMinRequests &&
+			float64(o.errors)/float64(o.total) >= conf.ErrorRateThreshold
+	if !need || !c.canEject() {
+		c.mx.Unlock()
+		return
+	}
+
+	d := c.eject(ep, now)
+	c.mx.Unlock()
+	if conf.OnEject != nil {
+		conf.OnEject(ep.addr, d)
+	}
+}
+
+// 是否还允许驱逐节点, 需要加锁调用
+func (c *ClusterPool) canEject() bool {
+	ejected := 0
+	for _, ep := range c.endpoints {
+		if ep.outlier.ejected {
+			ejected++
+		}
+	}
+	max := len(c.endpoints) * c.conf.Outlier.MaxEjectionPercent / 100
+	if max < 1 {
+		max = 1
+	}
+	return ejected < max
+}
+
+// 驱逐节点并返回驱逐时间, 需要加锁调用
+func (c *ClusterPool) eject(ep *clusterEndpoint, now time.Time) time.Duration {
+	conf := &c.conf.Outlier
+	o := &ep.outlier
+
+	// 重新接纳后长时间正常则重置驱逐次数
+	if !o.readmitTime.IsZero() && now.Sub(o.readmitTime) > conf.MaxEjectionTime {
+		o.ejectCount = 0
+	}
+	o.ejectCount++
+	d := conf.ejectionTime(o.ejectCount)
+
+	o.ejected = true
+	o.ejectUntil = now.Add(d)
+	o.consecutive, o.total, o.errors = 0, 0, 0
+	return d
+}
+
+// 检查驱逐到期的节点并开始探测, 需要加锁调用
+func (c *ClusterPool) checkEjected() {
+	now := c.conf.Clock.Now()
+	for _, ep := range c.endpoints {
+		o := &ep.outlier
+		if o.ejected && !o.probing && !now.Before(o.ejectUntil) {
+			o.probing = true
+			go c.probe(ep)
+		}
+	}
+}
+
+// 探测驱逐到期的节点, 连续创建conn成功 ProbeSuccesses 次后重新接纳, 探测创建的conn会放入该节点的连接池
+func (c *ClusterPool) probe(ep *clusterEndpoint) {
+	conf := &c.conf.Outlier
+	for i := 0; i < conf.ProbeSuccesses; i++ {
+		err := ep.pool.applyConnectLoop()
+		if c.isClose() {
+			return
+		}
+		if err != nil {
+			c.mx.Lock()
+			ep.outlier.probing = false
+			d := c.eject(ep, c.conf.Clock.Now())
+			c.mx.Unlock()
+			if conf.OnEject != nil {
+				conf.OnEject(ep.addr, d)
+			}
+			return
+		}
+	}
+
+	c.mx.Lock()
+	ep.outlier = outlierState{
+		ejectCount:  ep.outlier.ejectCount,
+		readmitTime: c.conf.Clock.Now(),
+	}
+	c.mx.Unlock()
+	if conf.OnReadmit != nil {
+		conf.OnReadmit(ep.addr)
+	}
+}
+
+// 获取当前被驱逐的节点地址
+func (c *ClusterPool) EjectedEndpoints() []string {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	var addrs []string
+	for _, ep := range c.endpoints {
+		if ep.outlier.ejected {
+			addrs = append(addrs, ep.addr)
+		}
+	}
+	return addrs
+}
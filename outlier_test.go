@@ -0,0 +1,118 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// 可以控制节点是否可用的集群配置
+func makeTestOutlierConfig(down map[string]*int32, addrs ...string) *ClusterConfig {
+	conf := makeTestClusterConfig(addrs...)
+	conf.Retry.InitialDelay = time.Millisecond * 10
+	conf.CheckIdleInterval = time.Millisecond * 50
+	conf.WaitTimeout = time.Millisecond * 200
+	conf.Outlier.ConsecutiveErrors = 2
+	conf.Outlier.BaseEjectionTime = time.Millisecond * 300
+	conf.Outlier.ProbeSuccesses = 1
+	conf.Creator = func(ctx context.Context, addr string) (interface{}, error) {
+		if flag, ok := down[addr]; ok && atomic.LoadInt32(flag) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return testAddrConn{addr}, nil
+	}
+	return conf
+}
+
+func TestOutlierEjectAndReadmit(t *testing.T) {
+	bDown := int32(1)
+	conf := makeTestOutlierConfig(map[string]*int32{"b": &bDown}, "a", "b")
+	var ejectNum, readmitNum int32
+	conf.Outlier.OnEject = func(addr string, ejectionTime time.Duration) {
+		require.Equal(t, "b", addr)
+		atomic.AddInt32(&ejectNum, 1)
+	}
+	conf.Outlier.OnReadmit = func(addr string) {
+		require.Equal(t, "b", addr)
+		atomic.AddInt32(&readmitNum, 1)
+	}
+	p, err := NewClusterPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	// 补充conn连续失败后被驱逐
+	time.Sleep(time.Millisecond * 100)
+	require.Equal(t, []string{"b"}, p.EjectedEndpoints())
+	require.Equal(t, int32(1), atomic.LoadInt32(&ejectNum))
+
+	// 驱逐期间不会选择b
+	conns, count := getClusterConns(t, p, 4)
+	require.Equal(t, map[string]int{"a": 4}, count)
+	for _, conn := range conns {
+		require.Nil(t, p.Put(conn))
+	}
+
+	// 恢复后探测成功重新接纳
+	atomic.StoreInt32(&bDown, 0)
+	time.Sleep(time.Millisecond * 300)
+	_, _ = getClusterConns(t, p, 1) // 触发探测
+	time.Sleep(time.Millisecond * 100)
+	require.Empty(t, p.EjectedEndpoints())
+	require.Equal(t, int32(1), atomic.LoadInt32(&readmitNum))
+
+	_, count = getClusterConns(t, p, 4)
+	require.Equal(t, 2, count["b"])
+}
+
+// 节点全部不可用时只驱逐允许的比例, 被驱逐的节点仍可作为最后的选择
+func TestOutlierMaxEjectionPercent(t *testing.T) {
+	aDown, bDown := int32(1), int32(1)
+	conf := makeTestOutlierConfig(map[string]*int32{"a": &aDown, "b": &bDown}, "a", "b")
+	p, err := NewClusterPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	time.Sleep(time.Millisecond * 100)
+	require.Len(t, p.EjectedEndpoints(), 1)
+
+	atomic.StoreInt32(&aDown, 0)
+	atomic.StoreInt32(&bDown, 0)
+	_, err = p.Get(context.Background())
+	require.Nil(t, err)
+}
+
+// 按错误率驱逐
+func TestOutlierErrorRate(t *testing.T) {
+	conf := makeTestClusterConfig("a", "b")
+	conf.Outlier.ErrorRateThreshold = 0.5
+	conf.Outlier.ErrorRateMinRequests = 4
+	p, err := NewClusterPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	time.Sleep(time.Millisecond * 100) // 等待初始conn创建完毕后重置统计
+	ep := p.endpoints[0]
+	p.mx.Lock()
+	ep.outlier = outlierState{}
+	p.mx.Unlock()
+	for _, ok := range []bool{true, false, true} {
+		p.recordResult(ep, ok)
+	}
+	require.Empty(t, p.EjectedEndpoints())
+	p.recordResult(ep, false)
+	require.Equal(t, []string{"a"}, p.EjectedEndpoints())
+}
+
+func TestOutlierEjectionTime(t *testing.T) {
+	conf := OutlierConfig{BaseEjectionTime: time.Second, MaxEjectionTime: time.Second * 10}
+	conf.check()
+	require.Equal(t, time.Second, conf.ejectionTime(1))
+	require.Equal(t, time.Second*2, conf.ejectionTime(2))
+	require.Equal(t, time.Second*8, conf.ejectionTime(4))
+	require.Equal(t, time.Second*10, conf.ejectionTime(5))
+	require.Equal(t, time.Second*10, conf.ejectionTime(100))
+}
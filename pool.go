@@ -6,16 +6,29 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type IConnectPool interface {
 	// 获取
 	Get(ctx context.Context) (*Conn, error)
-	// 回收
-	Put(conn *Conn)
+	// 回收, 放回未被取出或不属于该连接池的conn会返回错误并且不影响连接池
+	Put(conn *Conn) error
+	// 丢弃已损坏的conn, conn不会再被复用, 会被关闭并释放活跃计数, reason为损坏的原因
+	Discard(conn *Conn, reason error) error
 	// 关闭连接池
 	Close()
+	// 优雅关闭连接池, 不再接受新的请求, 等待已有的请求完成以及所有conn放回后关闭, ctx到期时强制关闭
+	Shutdown(ctx context.Context) (ShutdownReport, error)
+	// 获取统计快照
+	Stats() Stats
+	// 运行时修改配置, 参考 ConnectPool.UpdateConfig
+	UpdateConfig(update func(conf *Config)) error
+	// 预热, 同步创建conn直到空闲conn达到n个, 参考 ConnectPool.Warmup
+	Warmup(ctx context.Context, n int) error
+	// 缩容, 主动释放最多n个空闲conn, 返回释放的数量
+	Shrink(n int) int
 }
 
 // 创造者
@@ -32,18 +45,34 @@ var (
 	ErrMaxWaitConnLimit   = errors.New("达到最大等待连接数")
 	ErrPoolClosed         = errors.New("连接池已关闭")
 	ErrWaitGetConnTimeout = errors.New("获取连接超时")
+	ErrBackendUnavailable = errors.New("后端不可用")
+	ErrConnNotOwned       = errors.New("conn不属于该连接池")
+	ErrConnNotBorrowed    = errors.New("conn未被取出, 可能已经放回")
+	ErrConnClosed         = errors.New("conn已被连接池移除")
 )
 
 type ConnectPool struct {
-	conf *Config
+	conf     atomic.Value  // 当前配置 *Config, 修改配置时整体替换
+	confMx   sync.Mutex    // 修改配置的锁
+	interval chan struct{} // 检查空闲间隔已修改的信号
 
-	waitList        *list.List    // 未取到活跃锁的等待请求列表, 元素为 *waitReq, 先进先出
-	activeWaitList  *list.List    // 已经取到活跃锁的等待请求列表, 元素为 *waitReq, 先进先出
-	connList        *list.List    // 已连接的conn列表, 元素为 *Conn, 后进先出
+	waitList        *list.List    // 未取到活跃锁的等待请求列表, 元素为 *waitReq, 按优先级先进先出
+	activeWaitList  *list.List    // 已经取到活跃锁的等待请求列表, 元素为 *waitReq, 按优先级先进先出
+	connList        *list.List    // 已连接的conn列表, 元素为 *Conn, 头部的conn优先取出, 顺序由 IdleStrategy 决定
 	connectingCount int           // 当前正在准备conn的数量, 连接无论成功与否都会-1, 这里不用atomic而是用锁确保精确
+	createFails     int32         // 创建conn连续失败次数, 创建成功后重置, atomic操作
+	breaker         *breaker      // 创建conn的熔断器, 未启用时为nil
+	lastCreateErr   atomic.Value  // 最后一次创建conn失败的记录 *createErrRecord, 创建成功后重置为nil
+	draining        bool          // 是否正在排空, 排空时不再接受新的请求
+	drained         chan struct{} // 排空完成信号, 开始排空时创建
 	activeNum       int           // 活跃计数
-	activeLock      chan struct{} // 活跃锁
+	activeLockNum   int           // 已被占用的活跃锁数量, 不超过 MaxActive
+	priorityWaitNum int           // 等待请求中优先级不为0的数量, 为0时等待列表直接先进先出
 	mx              sync.Mutex
+	stats           *poolStats // 累计计数器
+	obs             Observer   // 观察者, 未设置时为 NopObserver
+
+	borrowed map[*Conn]*borrowRecord // 已取出的conn, 只有开启泄漏检测时才会记录
 
 	close      chan struct{} // 关闭信号
 	baseCtx    context.Context
@@ -56,14 +85,22 @@ func NewConnectPool(conf *Config) (IConnectPool, error) {
 	}
 
 	pool := &ConnectPool{
-		conf: conf,
+		interval: make(chan struct{}, 1),
 
 		waitList:       list.New(),
 		activeWaitList: list.New(),
 		connList:       list.New(),
+		borrowed:       make(map[*Conn]*borrowRecord),
+		stats:          new(poolStats),
+		breaker:        newBreaker(&conf.Breaker, conf.Clock),
+		obs:            conf.Observer,
 
 		close: make(chan struct{}),
 	}
+	if pool.obs == nil {
+		pool.obs = NopObserver{}
+	}
+	pool.conf.Store(conf)
 	pool.baseCtx, pool.baseCancel = context.WithCancel(context.Background())
 
 	// 初始化连接
@@ -73,70 +110,155 @@ func NewConnectPool(conf *Config) (IConnectPool, error) {
 	}
 
 	// 检查空闲循环
-	go pool.checkIdleLoop()
+	go pool.checkIdleLoop(conf.Clock.NewTicker(conf.CheckIdleInterval))
 
 	return pool, nil
 }
 
 func (c *ConnectPool) Get(ctx context.Context) (*Conn, error) {
-	return c.getLoop(ctx)
+	if o, ok := c.obs.(GetStartObserver); ok {
+		ctx = o.OnGetStart(ctx)
+	}
+	o, observeErr := c.obs.(GetErrorObserver)
+	var start time.Time
+	if observeErr {
+		start = c.now()
+	}
+
+	conn, err := c.getLoop(ctx)
+	if err != nil {
+		if observeErr {
+			o.OnGetError(ctx, err, GetInfo{Waited: c.since(start)})
+		}
+		return nil, err
+	}
+
+	c.trackBorrow(conn)
+	return conn, nil
 }
 
 // 放回conn, 每次放回都会导致活跃计数-1
-func (c *ConnectPool) Put(conn *Conn) {
+func (c *ConnectPool) Put(conn *Conn) error {
+	// 先修改状态, 并发重复放回时只有一个能成功, 成功后才调用回调和检查
+	if err := c.returnBorrowedConn(conn, connStateIdle); err != nil {
+		return err
+	}
+
+	c.obs.OnPut(conn)
+	healthy := c.healthCheckOnReturn(conn)
 	c.mx.Lock()
+	atomic.StoreInt64(&conn.lastUseTime, c.now().UnixNano())
+	delete(c.borrowed, conn)
 
 	c.activeNum--
 
 	if c.isClose() {
-		c.CloseConn(conn)
-		return
+		c.mx.Unlock()
+		c.closeConn(conn, CloseReasonPoolClosed)
+		return nil
 	}
 
 	// 放入活跃锁
 	c.putActiveLock()
 
 	// 校验conn, 如果失败从conn列表中获取一个有效的
-	for !c.validConn(conn) {
+	if !healthy {
+		go c.closeConn(conn, CloseReasonUnhealthy)
+	}
+	if !healthy || !c.validConn(conn) {
 		conn = c.popFrontConn()
 		if conn == nil {
+			c.checkDrained()
 			c.mx.Unlock()
 			c.replenishLackConn()
-			return
+			return nil
 		}
-		conn.putTimeSec = 0 // 取出后需要重置放入时间
 	}
 
 	// 立即使用这个conn
 	if c.useConn(conn) {
 		c.mx.Unlock()
-		return
+		return nil
 	}
 
-	// 后进先出, 以便尽早被获取
-	conn.putTimeSec = time.Now().Unix()
-	c.connList.PushFront(conn)
+	// 按选择策略放入, 默认后进先出, 以便尽早被获取
+	conn.putTime = c.now().UnixNano()
+	c.pushIdleConn(conn, true)
+	c.checkDrained()
 	c.mx.Unlock()
+	return nil
 }
 
-func (c *ConnectPool) Close() {
-	select {
-	case <-c.close:
-		return
-	default:
-		close(c.close)
-		c.baseCancel()
+// 丢弃conn, 用于调用者发现conn已损坏时. conn会被关闭, 同时释放活跃计数并补充缺少的conn
+func (c *ConnectPool) Discard(conn *Conn, reason error) error {
+	if err := c.returnBorrowedConn(conn, connStateClosed); err != nil {
+		return err
 	}
 
-	// 释放当前所有已连接的conn
 	c.mx.Lock()
-	defer c.mx.Unlock()
+	delete(c.borrowed, conn)
+
+	c.activeNum--
+
+	closed := c.isClose()
+	if !closed {
+		c.putActiveLock() // 放入活跃锁
+	}
+	c.checkDrained()
+	c.mx.Unlock()
 
-	for c.connList.Len() > 0 {
-		conn := c.connList.Remove(c.connList.Front()).(*Conn)
-		c.CloseConn(conn)
+	if o, ok := c.obs.(DiscardObserver); ok {
+		o.OnDiscard(conn, reason)
 	}
+	c.closeConn(conn, CloseReasonDiscard)
+
+	if !closed {
+		c.replenishLackConn()
+	}
+	return nil
+}
+
+// 获取当前配置
+func (c *ConnectPool) config() *Config {
+	return c.conf.Load().(*Config)
+}
+
+// 当前时间, 由 Config.Clock 提供
+func (c *ConnectPool) now() time.Time {
+	return c.config().Clock.Now()
+}
+
+// 从t到现在经过的时间, 由 Config.Clock 提供
+func (c *ConnectPool) since(t time.Time) time.Duration {
+	return c.config().Clock.Since(t)
+}
+
+func (c *ConnectPool) Close() {
+	c.closePool()
+}
+
+// 关闭连接池, 返回关闭的空闲conn数量和仍未放回的conn数量
+func (c *ConnectPool) closePool() (closedIdle, active int) {
+	c.mx.Lock()
+	if c.isClose() {
+		c.mx.Unlock()
+		return 0, 0
+	}
+	close(c.close)
+	c.baseCancel()
+
+	// 释放当前所有已连接的conn
+	connList := c.connList
 	c.connList = list.New()
+	active = c.activeNum
+	c.mx.Unlock()
+
+	for connList.Len() > 0 {
+		conn := connList.Remove(connList.Front()).(*Conn)
+		c.closeConn(conn, CloseReasonPoolClosed)
+		closedIdle++
+	}
+	return closedIdle, active
 }
 
 // 连接池是否已关闭
@@ -150,49 +272,84 @@ func (c *ConnectPool) isClose() bool {
 }
 
 func (c *ConnectPool) getLoop(ctx context.Context) (*Conn, error) {
+	c.stats.addGet()
 	if c.isClose() {
 		return nil, ErrPoolClosed
 	}
 
 	c.mx.Lock()
 
-	// 如果有活跃限制, 需要拿到锁, 否则放入未取到活跃锁的等待请求列表
-	if c.conf.MaxActive > 0 {
-		select {
-		case <-c.activeLock:
-		default:
-			req, err := c.addWaitReq(false) // 添加到等待队列
-			c.mx.Unlock()
+	// 排空中不再接受新的请求
+	if c.draining {
+		c.mx.Unlock()
+		return nil, ErrPoolClosed
+	}
 
-			if err != nil {
-				return nil, err
-			}
+	// 需要拿到活跃锁, 否则放入未取到活跃锁的等待请求列表
+	if !c.tryActiveLock() {
+		req, err := c.addWaitReq(false, PriorityFromContext(ctx)) // 添加到等待队列
+		c.mx.Unlock()
 
-			return c.waitReqGetConnLoop(ctx, req)
+		if err != nil {
+			return nil, err
 		}
+
+		c.observeWaitStart(ctx, req)
+		return c.waitReqGetConnLoop(ctx, req)
 	}
 
 	// 先从conn池中获取
-	conn := c.popFrontConn()
-	if conn != nil {
+	for {
+		conn, idleSince := c.popFrontConnWithIdle()
+		if conn == nil {
+			break
+		}
+
+		// 检查期间conn计入活跃, 检查不持有锁
 		c.activeNum++
 		c.mx.Unlock()
+		if !c.healthCheckOnBorrow(ctx, conn, idleSince) {
+			go c.closeConn(conn, CloseReasonUnhealthy)
+			c.mx.Lock()
+			c.activeNum--
+			continue
+		}
+
+		c.mx.Lock()
+		info := GetInfo{Fresh: conn.markBorrowed()}
+		c.mx.Unlock()
+		c.obs.OnGet(ctx, conn, info)
 		return conn, nil
 	}
 
+	// 后端已知不可用时立即失败, 不再等待
+	if err := c.breaker.unavailable(); err != nil {
+		c.putActiveLock()
+		c.mx.Unlock()
+		return nil, err
+	}
+
 	// 否则加入已经取到活跃锁的等待请求列表
-	req, err := c.addWaitReq(true)
+	req, err := c.addWaitReq(true, PriorityFromContext(ctx))
 	c.mx.Unlock()
 	if err != nil {
 		return nil, err
 	}
 
-	// 立即补充缺少的
+	// 先通知开始等待, 再补充缺少的, 这样创建时能看到这个等待请求
+	c.observeWaitStart(ctx, req)
 	c.replenishLackConn()
 
 	return c.waitReqGetConnLoop(ctx, req)
 }
 
+// 通知观察者开始排队等待
+func (c *ConnectPool) observeWaitStart(ctx context.Context, req *waitReq) {
+	if o, ok := c.obs.(GetStartObserver); ok {
+		o.OnWaitStart(ctx, req.pos)
+	}
+}
+
 // 自动放入conn处理
 func (c *ConnectPool) autoPutConn(conn *Conn) {
 	if !c.validConn(conn) {
@@ -200,12 +357,12 @@ func (c *ConnectPool) autoPutConn(conn *Conn) {
 	}
 
 	c.mx.Lock()
-	defer c.mx.Unlock()
-
 	if c.isClose() {
-		c.CloseConn(conn)
+		c.mx.Unlock()
+		c.closeConn(conn, CloseReasonPoolClosed)
 		return
 	}
+	defer c.mx.Unlock()
 
 	// 立即使用这个conn
 	if c.useConn(conn) {
@@ -213,6 +370,6 @@ func (c *ConnectPool) autoPutConn(conn *Conn) {
 	}
 
 	// 放入conn列表, 自动放入的conn应该放在列表末尾
-	conn.putTimeSec = time.Now().Unix()
-	c.connList.PushBack(conn)
+	conn.putTime = c.now().UnixNano()
+	c.pushIdleConn(conn, false)
 }
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/zlyuancn/connpool/clock"
 )
 
 type testConn struct{}
@@ -16,6 +17,31 @@ func testCreator(ctx context.Context) (interface{}, error) { return testConn{},
 func testConnClose(conn *Conn)                             {}
 func testValidConnected(conn *Conn) bool                   { return true }
 
+// 创建定时器时发出通知的时钟, 用于等待某个定时器开始计时
+type notifyClock struct {
+	*clock.Fake
+	timers chan time.Duration
+}
+
+func newNotifyClock() notifyClock {
+	return notifyClock{Fake: clock.NewFake(time.Time{}), timers: make(chan time.Duration, 16)}
+}
+
+func (c notifyClock) NewTimer(d time.Duration) clock.Timer {
+	t := c.Fake.NewTimer(d)
+	c.timers <- d
+	return t
+}
+
+// 等待时长为d的定时器被创建
+func (c notifyClock) waitTimer(d time.Duration) {
+	for got := range c.timers {
+		if got == d {
+			return
+		}
+	}
+}
+
 func makeTestConfig() *Config {
 	conf := NewConfig()
 	conf.Creator = testCreator
@@ -63,15 +89,23 @@ func TestGetPoolClose(t *testing.T) {
 
 // 获取超时
 func TestGetTimeout(t *testing.T) {
+	clk := newNotifyClock()
 	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MinIdle = 1 // 创建完初始的conn后不会再有创建中的conn
 	conf.MaxActive = 1
-	conf.WaitTimeout = time.Millisecond * 300
+	conf.WaitTimeout = time.Minute
+	conf.Clock = clk
 	p, err := NewConnectPool(conf)
 	require.Nil(t, err)
 
 	_, err = p.Get(context.Background())
 	require.Nil(t, err)
 
+	go func() {
+		clk.waitTimer(time.Minute) // 等待超时的timer
+		clk.Advance(time.Minute)
+	}()
 	_, err = p.Get(context.Background())
 	require.Equal(t, ErrWaitGetConnTimeout, err)
 	p.Close()
@@ -94,6 +128,30 @@ func TestGetTimeoutCtx(t *testing.T) {
 	p.Close()
 }
 
+// 获取超时时包含最后一次创建失败的错误
+func TestGetTimeoutCreateErr(t *testing.T) {
+	conf := makeTestConfig()
+	conf.WaitTimeout = time.Millisecond * 300
+	conf.Retry.MaxAttempts = 1
+	createErr := errors.New("connection refused")
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		return nil, createErr
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	_, err = p.Get(context.Background())
+	require.True(t, errors.Is(err, ErrWaitGetConnTimeout))
+	require.Equal(t, createErr, errors.Unwrap(err))
+
+	var timeoutErr *WaitTimeoutError
+	require.True(t, errors.As(err, &timeoutErr))
+	require.False(t, timeoutErr.LastCreateTime.IsZero())
+	require.Contains(t, err.Error(), ErrWaitGetConnTimeout.Error())
+	require.Contains(t, err.Error(), createErr.Error())
+}
+
 // 获取时达到最大等待数
 func TestGetLimitWaitCount(t *testing.T) {
 	conf := makeTestConfig()
@@ -275,32 +333,62 @@ func TestRepeatClose(t *testing.T) {
 
 // 获取conn时conn存活超时
 func TestConnLifetimeTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	created := make(chan struct{}, 4)
+	closed := make(chan struct{}, 4)
 	conf := makeTestConfig()
+	conf.Clock = clk
+	conf.WaitFirstConn = true // 初始的conn创建完毕后返回
 	conf.MinIdle = 1
 	conf.BatchIncrement = 1
 	conf.MaxConnLifetime = time.Second
 	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		created <- struct{}{}
+		return testConn{}, nil
+	}
+	conf.ConnClose = func(conn *Conn) {
+		closed <- struct{}{}
+	}
 	p, err := NewConnectPool(conf)
 	require.Nil(t, err)
-	time.Sleep(time.Millisecond * 200) // 等待主动创建完毕
+	defer p.Close()
+	<-created
 
-	closeNum := 0
-	conf.ConnClose = func(conn *Conn) {
-		closeNum += 1
-	}
+	clk.Advance(time.Second * 2)         // 等待conn超时
+	_, err = p.Get(context.Background()) // 重新获取
+	require.Nil(t, err)
+	<-closed  // 超时的conn被关闭
+	<-created // 重新创建的1个
+	<-created // 最小空闲1个
+	require.Len(t, created, 0)
+	require.Len(t, closed, 0)
+}
 
-	creatorNum := 0
+// 连接超时由时钟控制
+func TestConnectTimeoutClock(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	started := make(chan struct{}, 1)
+	errs := make(chan error, 1)
+	conf := makeTestConfig()
+	conf.Clock = clk
+	conf.MinIdle = 1
+	conf.ConnectTimeout = time.Second
+	conf.Retry.MaxAttempts = 1
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
 	conf.Creator = func(ctx context.Context) (interface{}, error) {
-		creatorNum++
-		return testConn{}, nil
+		started <- struct{}{} // 连接超时的timer在调用前创建
+		<-ctx.Done()
+		errs <- ctx.Err()
+		return nil, ctx.Err()
 	}
-
-	time.Sleep(time.Second * 2)          // 等待conn超时
-	_, err = p.Get(context.Background()) // 重新获取
+	p, err := NewConnectPool(conf)
 	require.Nil(t, err)
-	time.Sleep(time.Millisecond * 200) // 等待主动补足完毕
-	require.Equal(t, 2, creatorNum)    // 2 = 重新创建的1个 + 最小空闲1个
-	require.Equal(t, 1, closeNum)
+	defer p.Close()
+
+	<-started
+	clk.Advance(time.Second)
+	require.Equal(t, context.DeadlineExceeded, <-errs)
 }
 
 // 获取conn时无效
@@ -334,111 +422,126 @@ func TestGetConnValid(t *testing.T) {
 
 // 获取conn时空闲超时
 func TestGetConnIdleTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	created := make(chan struct{}, 4)
+	closed := make(chan struct{}, 4)
 	conf := makeTestConfig()
+	conf.Clock = clk
+	conf.WaitFirstConn = true // 初始的conn创建完毕后返回
 	conf.MinIdle = 1
 	conf.BatchIncrement = 1
 	conf.IdleTimeout = time.Second
 	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
-	p, err := NewConnectPool(conf)
-	require.Nil(t, err)
-	time.Sleep(time.Millisecond * 200) // 等待主动创建完毕
-
-	closeNum := 0
-	conf.ConnClose = func(conn *Conn) {
-		closeNum += 1
-	}
-
-	creatorNum := 0
 	conf.Creator = func(ctx context.Context) (interface{}, error) {
-		creatorNum++
+		created <- struct{}{}
 		return testConn{}, nil
 	}
+	conf.ConnClose = func(conn *Conn) {
+		closed <- struct{}{}
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+	<-created
 
-	time.Sleep(time.Second * 2)          // 等待conn超时
+	clk.Advance(time.Second * 2)         // 等待conn超时
 	_, err = p.Get(context.Background()) // 重新获取
 	require.Nil(t, err)
-	time.Sleep(time.Millisecond * 200) // 等待主动补足完毕
-	require.Equal(t, 2, creatorNum)    // 2 = 重新创建的1个 + 最小空闲1个
-	require.Equal(t, 1, closeNum)
+	<-closed  // 超时的conn被关闭
+	<-created // 重新创建的1个
+	<-created // 最小空闲1个
+	require.Len(t, created, 0)
+	require.Len(t, closed, 0)
 }
 
 // conn自动空闲超时
 func TestAutoConnIdleTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	created := make(chan struct{}, 4)
+	closed := make(chan struct{}, 4)
 	conf := makeTestConfig()
+	conf.Clock = clk
+	conf.WaitFirstConn = true // 初始的conn创建完毕后返回
 	conf.MinIdle = 1
 	conf.BatchIncrement = 1
 	conf.IdleTimeout = time.Second
 	conf.CheckIdleInterval = time.Second // 将自动补足时间变短
-	p, err := NewConnectPool(conf)
-	defer p.Close()
-	require.Nil(t, err)
-	time.Sleep(time.Millisecond * 200) // 等待主动创建完毕
-
-	closeNum := 0
-	conf.ConnClose = func(conn *Conn) {
-		closeNum += 1
-	}
-
-	creatorNum := 0
 	conf.Creator = func(ctx context.Context) (interface{}, error) {
-		creatorNum++
+		created <- struct{}{}
 		return testConn{}, nil
 	}
+	conf.ConnClose = func(conn *Conn) {
+		closed <- struct{}{}
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+	<-created
 
-	time.Sleep(time.Second * 1)        // 等待触发检查
-	time.Sleep(time.Millisecond * 200) // 等待主动补足完毕
-	require.Equal(t, 1, creatorNum)    // 最小空闲1个
-	require.Equal(t, 1, closeNum)      // 自动关闭1个
+	clk.Advance(time.Second) // 触发检查
+	<-closed                 // 自动关闭1个
+	<-created                // 补充最小空闲1个
+	require.Len(t, created, 0)
+	require.Len(t, closed, 0)
 }
 
 // conn自动存活超时
 func TestAutoConnLifetimeTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	created := make(chan struct{}, 4)
+	closed := make(chan struct{}, 4)
 	conf := makeTestConfig()
+	conf.Clock = clk
+	conf.WaitFirstConn = true // 初始的conn创建完毕后返回
 	conf.MinIdle = 1
 	conf.BatchIncrement = 1
 	conf.MaxConnLifetime = time.Second
 	conf.CheckIdleInterval = time.Second // 将自动补足时间变短
-	p, err := NewConnectPool(conf)
-	defer p.Close()
-	require.Nil(t, err)
-	time.Sleep(time.Millisecond * 200) // 等待主动创建完毕
-
-	closeNum := 0
-	conf.ConnClose = func(conn *Conn) {
-		closeNum += 1
-	}
-
-	creatorNum := 0
 	conf.Creator = func(ctx context.Context) (interface{}, error) {
-		creatorNum++
+		created <- struct{}{}
 		return testConn{}, nil
 	}
+	conf.ConnClose = func(conn *Conn) {
+		closed <- struct{}{}
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+	<-created
 
-	time.Sleep(time.Second * 1)        // 等待触发检查
-	time.Sleep(time.Millisecond * 200) // 等待主动补足完毕
-	require.Equal(t, 1, creatorNum)    // 最小空闲1个
-	require.Equal(t, 1, closeNum)      // 自动关闭1个
+	clk.Advance(time.Second) // 触发检查
+	<-closed                 // 自动关闭1个
+	<-created                // 补充最小空闲1个
+	require.Len(t, created, 0)
+	require.Len(t, closed, 0)
 }
 
 // 触发检查时无需补充数量
 func TestNoReplenish(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	checked := make(chan struct{}, 4)
 	conf := makeTestConfig()
+	conf.Clock = clk
+	conf.WaitFirstConn = true // 初始的conn创建完毕后返回
 	conf.MinIdle = 1
 	conf.CheckIdleInterval = time.Second // 将自动补足时间变短
 	p, err := NewConnectPool(conf)
-	defer p.Close()
 	require.Nil(t, err)
-	time.Sleep(time.Millisecond * 200) // 等待主动创建完毕
+	defer p.Close()
 
-	creatorNum := 0
-	conf.Creator = func(ctx context.Context) (interface{}, error) {
-		creatorNum++
-		return testConn{}, nil
+	// 每次检查都会先校验空闲的conn, 第二次检查开始时说明第一次检查已经结束
+	conf.ValidConnected = func(conn *Conn) bool {
+		checked <- struct{}{}
+		return true
 	}
-
-	time.Sleep(time.Second * 1)        // 等待触发检查
-	time.Sleep(time.Millisecond * 200) // 等待主动补足完毕
-	require.Equal(t, 0, creatorNum)    // 没有补充
+	clk.Advance(time.Second) // 触发检查
+	<-checked
+	clk.Advance(time.Second)
+	<-checked
+
+	st := p.Stats()
+	require.Equal(t, int64(1), st.CreateCount) // 没有补充
+	require.Equal(t, 0, st.ConnectingCount)
 }
 
 // 自动释放时没有conn
@@ -521,3 +624,188 @@ func TestAutoReleaseNeedlessConn(t *testing.T) {
 	time.Sleep(time.Second)       // 再次等待触发
 	require.Equal(t, 1, closeNum) // 当前数量3个 - 最大空闲2个
 }
+
+// 重复放回
+func TestRepeatPut(t *testing.T) {
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MaxActive = 1
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	require.Nil(t, p.Put(conn))
+	require.Equal(t, ErrConnNotBorrowed, p.Put(conn))
+	require.Equal(t, 0, p.Stats().ActiveCount)
+
+	// 重复放回不会导致活跃锁溢出
+	for i := 0; i < 3; i++ {
+		conn, err = p.Get(context.Background())
+		require.Nil(t, err)
+		require.Nil(t, p.Put(conn))
+		require.NotNil(t, p.Put(conn))
+	}
+}
+
+// 并发重复放回时只有一个成功, 回调和检查只执行一次
+func TestConcurrentPut(t *testing.T) {
+	obs := newTestObserver()
+	checkNum := int32(0)
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.Observer = obs
+	conf.HealthChecker = HealthCheckFunc(func(ctx context.Context, conn *Conn) error {
+		atomic.AddInt32(&checkNum, 1)
+		time.Sleep(time.Millisecond) // 放大并发放回的窗口
+		return nil
+	})
+	conf.HealthCheck.TestOnReturn = true
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	const n = 8
+	for i := 0; i < 20; i++ {
+		conn, err := p.Get(context.Background())
+		require.Nil(t, err)
+
+		start := make(chan struct{})
+		errs := make(chan error, n)
+		for j := 0; j < n; j++ {
+			go func() {
+				<-start
+				errs <- p.Put(conn)
+			}()
+		}
+		close(start)
+
+		success := 0
+		for j := 0; j < n; j++ {
+			if err := <-errs; err == nil {
+				success++
+			} else {
+				require.Equal(t, ErrConnNotBorrowed, err)
+			}
+		}
+		require.Equal(t, 1, success)
+	}
+
+	obs.mx.Lock()
+	defer obs.mx.Unlock()
+	require.Equal(t, 20, obs.put)
+	require.Equal(t, int32(20), atomic.LoadInt32(&checkNum))
+	require.Equal(t, 0, p.Stats().ActiveCount)
+}
+
+// 放回不属于该连接池的conn
+func TestPutForeignConn(t *testing.T) {
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	p1, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p1.Close()
+	p2, err := NewConnectPool(makeTestConfig())
+	require.Nil(t, err)
+	defer p2.Close()
+
+	conn, err := p1.Get(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, ErrConnNotOwned, p2.Put(conn))
+	require.Equal(t, ErrConnNotOwned, p2.Put(nil))
+	require.Equal(t, 0, p2.Stats().ActiveCount)
+	require.Nil(t, p1.Put(conn))
+}
+
+type testDiscardObserver struct {
+	NopObserver
+	reason error
+}
+
+func (o *testDiscardObserver) OnDiscard(conn *Conn, reason error) {
+	o.reason = reason
+}
+
+// 丢弃已损坏的conn
+func TestDiscard(t *testing.T) {
+	obs := &testDiscardObserver{}
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MinIdle = 1
+	conf.MaxActive = 1
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	conf.Observer = MultiObserver(obs)
+	closeNum := int32(0)
+	conf.ConnClose = func(conn *Conn) {
+		atomic.AddInt32(&closeNum, 1)
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	brokenErr := errors.New("broken pipe")
+	require.Nil(t, p.Discard(conn, brokenErr))
+	require.Equal(t, int32(1), atomic.LoadInt32(&closeNum))
+	require.Equal(t, brokenErr, obs.reason)
+	require.Equal(t, ErrConnClosed, p.Discard(conn, brokenErr))
+	require.Equal(t, ErrConnClosed, p.Put(conn))
+
+	conn2, err := p.Get(context.Background()) // 活跃锁已释放, 并且补充了新的conn
+	require.Nil(t, err)
+	require.NotEqual(t, conn, conn2)
+	st := p.Stats()
+	require.Equal(t, 1, st.ActiveCount)
+	require.Equal(t, int64(1), st.CloseCount[CloseReasonDiscard])
+}
+
+// conn的元数据和用户属性
+func TestConnMetadata(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MinIdle = 0
+	conf.MaxIdle = 1
+	conf.ValidConnected = func(conn *Conn) bool {
+		v, _ := conn.GetAttr("version")
+		return v != "old"
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	require.NotZero(t, conn.ID())
+	require.Equal(t, int64(1), conn.UseCount())
+	require.False(t, conn.CreatedAt().After(conn.LastUsedAt()))
+	require.WithinDuration(t, time.Now(), conn.LastUsedAt(), time.Second)
+
+	conn.SetAttr("addr", "127.0.0.1:6379")
+	v, ok := conn.GetAttr("addr")
+	require.True(t, ok)
+	require.Equal(t, "127.0.0.1:6379", v)
+	conn.DelAttr("addr")
+	_, ok = conn.GetAttr("addr")
+	require.False(t, ok)
+
+	lastUsed := conn.LastUsedAt()
+	time.Sleep(time.Millisecond)
+	require.Nil(t, p.Put(conn))
+	require.True(t, conn.LastUsedAt().After(lastUsed))
+
+	// 同一个conn被再次取出
+	conn2, err := p.Get(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, conn.ID(), conn2.ID())
+	require.Equal(t, int64(2), conn2.UseCount())
+
+	// ValidConnected 可以根据属性判断conn是否有效
+	conn2.SetAttr("version", "old")
+	require.Nil(t, p.Put(conn2))
+	conn3, err := p.Get(context.Background())
+	require.Nil(t, err)
+	require.NotEqual(t, conn.ID(), conn3.ID())
+	require.Equal(t, int64(1), conn3.UseCount())
+	require.Nil(t, p.Put(conn3))
+}
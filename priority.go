@@ -0,0 +1,67 @@
+package connpool
+
+import (
+	"container/list"
+	"context"
+	"time"
+)
+
+type priorityKey struct{}
+
+// 设置获取conn的优先级, 连接池繁忙时优先级高的请求先获取到conn, 默认优先级为0, 可以为负数
+//
+// 等待中的请求会随着等待时间提高优先级, 参考 Config.PriorityAging
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// 获取ctx中设置的优先级
+func PriorityFromContext(ctx context.Context) int {
+	priority, _ := ctx.Value(priorityKey{}).(int)
+	return priority
+}
+
+// waitReq在当前时间的有效优先级
+func (c *ConnectPool) effectivePriority(req *waitReq, now time.Time) int {
+	aging := c.config().PriorityAging
+	if aging < 1 {
+		return req.priority
+	}
+	return req.priority + int(now.Sub(req.enqueue)/aging)
+}
+
+// 选出列表中有效优先级最高的waitReq, 优先级相同时先加入的优先, 列表为空时返回nil
+func (c *ConnectPool) bestWaitReq(l *list.List) *list.Element {
+	if c.priorityWaitNum == 0 { // 都是默认优先级, 先进先出
+		return l.Front()
+	}
+
+	now := c.now()
+	var best *list.Element
+	bestPriority := 0
+	for e := l.Front(); e != nil; e = e.Next() {
+		p := c.effectivePriority(e.Value.(*waitReq), now)
+		if best == nil || p > bestPriority {
+			best, bestPriority = e, p
+		}
+	}
+	return best
+}
+
+// 选出列表中有效优先级最低的waitReq, 优先级相同时后加入的优先, 列表为空时返回nil
+func (c *ConnectPool) worstWaitReq(l *list.List) *list.Element {
+	if c.priorityWaitNum == 0 {
+		return l.Back()
+	}
+
+	now := c.now()
+	var worst *list.Element
+	worstPriority := 0
+	for e := l.Back(); e != nil; e = e.Prev() {
+		p := c.effectivePriority(e.Value.(*waitReq), now)
+		if worst == nil || p < worstPriority {
+			worst, worstPriority = e, p
+		}
+	}
+	return worst
+}
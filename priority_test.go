@@ -0,0 +1,101 @@
+package connpool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// 启动一个等待conn的请求, 获取到conn后发送name
+func startPriorityWaiter(p IConnectPool, name string, priority int, got chan<- string) {
+	go func() {
+		conn, err := p.Get(WithPriority(context.Background(), priority))
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		got <- name
+		_ = p.Put(conn)
+	}()
+}
+
+func TestPriority(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MaxActive = 1
+	conf.PriorityAging = 0
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+
+	got := make(chan string, 3)
+	startPriorityWaiter(p, "batch", -1, got)
+	time.Sleep(time.Millisecond * 50)
+	startPriorityWaiter(p, "normal", 0, got)
+	time.Sleep(time.Millisecond * 50)
+	startPriorityWaiter(p, "api", 10, got)
+	time.Sleep(time.Millisecond * 50)
+
+	require.Nil(t, p.Put(conn))
+	require.Equal(t, "api", <-got)
+	require.Equal(t, "normal", <-got)
+	require.Equal(t, "batch", <-got)
+}
+
+// 等待时间足够长的低优先级请求不会被饿死
+func TestPriorityAging(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MaxActive = 1
+	conf.PriorityAging = time.Millisecond * 50
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+
+	got := make(chan string, 2)
+	startPriorityWaiter(p, "batch", 0, got)
+	time.Sleep(time.Millisecond * 300)
+	startPriorityWaiter(p, "api", 3, got)
+	time.Sleep(time.Millisecond * 50)
+
+	require.Nil(t, p.Put(conn))
+	require.Equal(t, "batch", <-got)
+	require.Equal(t, "api", <-got)
+}
+
+// 调小最大等待数量时先拒绝优先级最低的请求
+func TestPriorityReject(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MaxActive = 1
+	conf.PriorityAging = 0
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+
+	got := make(chan string, 2)
+	startPriorityWaiter(p, "batch", -1, got)
+	time.Sleep(time.Millisecond * 50)
+	startPriorityWaiter(p, "api", 1, got)
+	time.Sleep(time.Millisecond * 50)
+
+	require.Nil(t, p.UpdateConfig(func(conf *Config) {
+		conf.MaxWaitConnCount = 1
+	}))
+	require.Equal(t, ErrMaxWaitConnLimit.Error(), <-got)
+	require.Nil(t, p.Put(conn))
+	require.Equal(t, "api", <-got)
+}
+
+func TestPriorityFromContext(t *testing.T) {
+	require.Equal(t, 0, PriorityFromContext(context.Background()))
+	require.Equal(t, 5, PriorityFromContext(WithPriority(context.Background(), 5)))
+}
@@ -0,0 +1,150 @@
+// Package prometheus 提供连接池的 prometheus 指标收集器
+package prometheus
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/zlyuancn/connpool"
+)
+
+const namespace = "connpool"
+
+// 连接池指标收集器
+//
+// 同时实现了 connpool.Observer 和 prometheus.Collector,
+// 需要设置到 connpool.Config.Observer 中, 创建连接池后通过 SetPool 绑定连接池, 然后注册到 prometheus.
+//
+// 直方图由观察者事件驱动, 每次事件记录一次. 空闲, 活跃, 创建中, 等待数量等状态指标在采集时读取 Stats:
+// Stats 在连接池的锁内生成, 各数量之间是一致的快照, 而由事件累加的计数在事件遗漏或乱序时会持续偏差,
+// 且每次采集只加一次锁, 不会给 Get 和 Put 增加开销. 累计次数同样来自 Stats, 与连接池自身的统计一致.
+//
+//	c := prometheus.NewCollector("redis")
+//	conf.Observer = c
+//	pool, _ := connpool.NewConnectPool(conf)
+//	c.SetPool(pool)
+//	registry.MustRegister(c)
+type Collector struct {
+	connpool.NopObserver
+
+	mx   sync.RWMutex
+	pool connpool.IConnectPool
+
+	idle       *prom.Desc
+	active     *prom.Desc
+	connecting *prom.Desc
+	waiters    *prom.Desc
+	timeouts   *prom.Desc
+	createErrs *prom.Desc
+	closed     *prom.Desc
+
+	waitLatency   prom.Histogram
+	createLatency prom.Histogram
+	connAge       prom.Histogram
+}
+
+// 创建指标收集器, name 会作为所有指标的 pool 标签
+func NewCollector(name string) *Collector {
+	labels := prom.Labels{"pool": name}
+	desc := func(metric, help string, variableLabels ...string) *prom.Desc {
+		return prom.NewDesc(prom.BuildFQName(namespace, "", metric), help, variableLabels, labels)
+	}
+	return &Collector{
+		idle:       desc("idle_connections", "空闲conn数量"),
+		active:     desc("active_connections", "活跃conn数量, 即已被取出未放回的conn"),
+		connecting: desc("connecting_connections", "正在创建的conn数量"),
+		waiters:    desc("waiters", "等待获取conn的请求数量"),
+		timeouts:   desc("wait_timeouts_total", "累计等待获取conn超时次数"),
+		createErrs: desc("create_errors_total", "累计创建conn失败次数"),
+		closed:     desc("closed_total", "按原因统计的累计释放conn次数", "reason"),
+
+		waitLatency: prom.NewHistogram(prom.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "wait_duration_seconds",
+			Help:        "获取conn的等待时间",
+			ConstLabels: labels,
+			Buckets:     []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5},
+		}),
+		createLatency: prom.NewHistogram(prom.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "create_duration_seconds",
+			Help:        "创建conn的耗时, 包含失败的创建",
+			ConstLabels: labels,
+			Buckets:     prom.DefBuckets,
+		}),
+		connAge: prom.NewHistogram(prom.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "connection_age_seconds",
+			Help:        "conn被释放时的存活时间",
+			ConstLabels: labels,
+			Buckets:     []float64{1, 10, 60, 300, 600, 1800, 3600, 7200, 86400},
+		}),
+	}
+}
+
+// 绑定连接池, 绑定后才会输出连接池状态相关的指标
+func (c *Collector) SetPool(pool connpool.IConnectPool) {
+	c.mx.Lock()
+	c.pool = pool
+	c.mx.Unlock()
+}
+
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	ch <- c.idle
+	ch <- c.active
+	ch <- c.connecting
+	ch <- c.waiters
+	ch <- c.timeouts
+	ch <- c.createErrs
+	ch <- c.closed
+	c.waitLatency.Describe(ch)
+	c.createLatency.Describe(ch)
+	c.connAge.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	c.waitLatency.Collect(ch)
+	c.createLatency.Collect(ch)
+	c.connAge.Collect(ch)
+
+	c.mx.RLock()
+	pool := c.pool
+	c.mx.RUnlock()
+	if pool == nil {
+		return
+	}
+
+	// 状态指标读取采集时的快照, 见 Collector 的说明
+	st := pool.Stats()
+	ch <- prom.MustNewConstMetric(c.idle, prom.GaugeValue, float64(st.IdleCount))
+	ch <- prom.MustNewConstMetric(c.active, prom.GaugeValue, float64(st.ActiveCount))
+	ch <- prom.MustNewConstMetric(c.connecting, prom.GaugeValue, float64(st.ConnectingCount))
+	ch <- prom.MustNewConstMetric(c.waiters, prom.GaugeValue, float64(st.WaitQueueLen+st.ActiveWaitQueueLen))
+	ch <- prom.MustNewConstMetric(c.timeouts, prom.CounterValue, float64(st.TimeoutCount))
+	ch <- prom.MustNewConstMetric(c.createErrs, prom.CounterValue, float64(st.CreateFailCount))
+	for reason, n := range st.CloseCount {
+		ch <- prom.MustNewConstMetric(c.closed, prom.CounterValue, float64(n), reason.String())
+	}
+}
+
+func (c *Collector) OnCreate(conn *connpool.Conn, cost time.Duration) {
+	c.createLatency.Observe(cost.Seconds())
+}
+
+func (c *Collector) OnCreateError(err error, cost time.Duration) {
+	c.createLatency.Observe(cost.Seconds())
+}
+
+func (c *Collector) OnGet(ctx context.Context, conn *connpool.Conn, info connpool.GetInfo) {
+	c.waitLatency.Observe(info.Waited.Seconds())
+}
+
+func (c *Collector) OnWaitTimeout(ctx context.Context, info connpool.GetInfo) {
+	c.waitLatency.Observe(info.Waited.Seconds())
+}
+
+func (c *Collector) OnEvict(conn *connpool.Conn, reason connpool.CloseReason) {
+	c.connAge.Observe(conn.Age().Seconds())
+}
@@ -0,0 +1,110 @@
+package prometheus
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/zlyuancn/connpool"
+	"github.com/zlyuancn/connpool/clock"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector("test")
+
+	conf := connpool.NewConfig()
+	conf.WaitFirstConn = true
+	conf.MinIdle = 1
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	conf.Creator = func(ctx context.Context) (interface{}, error) { return struct{}{}, nil }
+	conf.ConnClose = func(conn *connpool.Conn) {}
+	conf.Observer = c
+	pool, err := connpool.NewConnectPool(conf)
+	require.Nil(t, err)
+
+	reg := prom.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	// 未绑定连接池时只有直方图
+	n, err := testutil.GatherAndCount(reg, "connpool_active_connections")
+	require.Nil(t, err)
+	require.Equal(t, 0, n)
+	c.SetPool(pool)
+
+	conn, err := pool.Get(context.Background())
+	require.Nil(t, err)
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP connpool_active_connections 活跃conn数量, 即已被取出未放回的conn
+# TYPE connpool_active_connections gauge
+connpool_active_connections{pool="test"} 1
+`), "connpool_active_connections")
+	require.Nil(t, err)
+
+	n, err = testutil.GatherAndCount(reg, "connpool_wait_duration_seconds", "connpool_create_duration_seconds")
+	require.Nil(t, err)
+	require.Equal(t, 2, n)
+
+	pool.Put(conn)
+	pool.Close()
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP connpool_active_connections 活跃conn数量, 即已被取出未放回的conn
+# TYPE connpool_active_connections gauge
+connpool_active_connections{pool="test"} 0
+`), "connpool_active_connections")
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), histogramCount(t, reg, "connpool_connection_age_seconds"))
+	require.Equal(t, uint64(1), histogramCount(t, reg, "connpool_wait_duration_seconds"))
+}
+
+// conn的存活时间由连接池的时钟计算
+func TestCollectorConnAge(t *testing.T) {
+	c := NewCollector("test")
+	fake := clock.NewFake(time.Time{})
+
+	conf := connpool.NewConfig()
+	conf.WaitFirstConn = true
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	conf.Clock = fake
+	conf.Creator = func(ctx context.Context) (interface{}, error) { return struct{}{}, nil }
+	conf.ConnClose = func(conn *connpool.Conn) {}
+	conf.Observer = c
+	pool, err := connpool.NewConnectPool(conf)
+	require.Nil(t, err)
+	defer pool.Close()
+
+	reg := prom.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	conn, err := pool.Get(context.Background())
+	require.Nil(t, err)
+	fake.Advance(time.Second * 10)
+	require.Nil(t, pool.Discard(conn, nil))
+
+	mfs, err := reg.Gather()
+	require.Nil(t, err)
+	for _, mf := range mfs {
+		if mf.GetName() == "connpool_connection_age_seconds" {
+			require.Equal(t, float64(10), mf.GetMetric()[0].GetHistogram().GetSampleSum())
+			return
+		}
+	}
+	t.Fatal("未找到指标 connpool_connection_age_seconds")
+}
+
+func histogramCount(t *testing.T, reg *prom.Registry, name string) uint64 {
+	mfs, err := reg.Gather()
+	require.Nil(t, err)
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	t.Fatalf("未找到指标 %s", name)
+	return 0
+}
@@ -0,0 +1,76 @@
+package connpool
+
+// 运行时修改配置, update 会收到当前配置的副本, 修改后的配置经过检查后整体生效
+//
+// 只有以下字段支持修改, 其它字段的修改会被忽略:
+// MinIdle, MaxIdle, MaxActive, BatchIncrement, BatchShrink, IdleTimeout, WaitTimeout,
+// MaxWaitConnCount, ConnectTimeout, MaxConnLifetime, CheckIdleInterval, PriorityAging.
+//
+// 调大 MaxActive 会立即唤醒等待活跃锁的请求, 调小时已取出的conn不受影响, 放回后才会生效.
+// 调小 MaxWaitConnCount 会拒绝超出的等待请求并返回 ErrMaxWaitConnLimit.
+func (c *ConnectPool) UpdateConfig(update func(conf *Config)) error {
+	c.confMx.Lock()
+	defer c.confMx.Unlock()
+
+	if c.isClose() {
+		return ErrPoolClosed
+	}
+
+	old := c.config()
+	conf, err := updatedConfig(old, update)
+	if err != nil {
+		return err
+	}
+
+	c.mx.Lock()
+	c.conf.Store(conf)
+
+	// 唤醒等待活跃锁的请求, 有空闲conn时直接交付
+	if c.grantActiveLock() > 0 {
+		for c.activeWaitList.Len() > 0 {
+			conn := c.popFrontConn()
+			if conn == nil {
+				break
+			}
+			c.useConn(conn)
+		}
+	}
+	c.rejectExcessWaitReq()
+	c.checkDrained()
+	c.mx.Unlock()
+
+	if conf.CheckIdleInterval != old.CheckIdleInterval {
+		select {
+		case c.interval <- struct{}{}:
+		default:
+		}
+	}
+
+	c.releaseNeedlessConn()
+	c.replenishLackConn()
+	return nil
+}
+
+// 将update对可修改字段的修改应用到old的副本上, 检查后返回新的配置
+func updatedConfig(old *Config, update func(conf *Config)) (*Config, error) {
+	tmp := *old
+	update(&tmp)
+
+	conf := *old
+	conf.MinIdle = tmp.MinIdle
+	conf.MaxIdle = tmp.MaxIdle
+	conf.MaxActive = tmp.MaxActive
+	conf.BatchIncrement = tmp.BatchIncrement
+	conf.BatchShrink = tmp.BatchShrink
+	conf.IdleTimeout = tmp.IdleTimeout
+	conf.WaitTimeout = tmp.WaitTimeout
+	conf.MaxWaitConnCount = tmp.MaxWaitConnCount
+	conf.ConnectTimeout = tmp.ConnectTimeout
+	conf.MaxConnLifetime = tmp.MaxConnLifetime
+	conf.CheckIdleInterval = tmp.CheckIdleInterval
+	conf.PriorityAging = tmp.PriorityAging
+	if err := conf.Check(); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
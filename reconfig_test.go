@@ -0,0 +1,121 @@
+package connpool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// 调大最大活跃连接数时唤醒等待的请求
+func TestUpdateConfigIncreaseMaxActive(t *testing.T) {
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MaxActive = 1
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Get(context.Background())
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 100)
+	require.Equal(t, 1, p.Stats().WaitQueueLen)
+
+	require.Nil(t, p.UpdateConfig(func(conf *Config) {
+		conf.MaxActive = 2
+	}))
+	select {
+	case err := <-done:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("等待的请求未被唤醒")
+	}
+	require.Equal(t, 2, p.Stats().MaxActive)
+	require.Nil(t, p.Put(conn))
+}
+
+// 调小最大活跃连接数时, 已取出的conn放回后才会生效
+func TestUpdateConfigDecreaseMaxActive(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MaxActive = 2
+	conf.WaitTimeout = time.Millisecond * 200
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn1, err := p.Get(context.Background())
+	require.Nil(t, err)
+	conn2, err := p.Get(context.Background())
+	require.Nil(t, err)
+
+	require.Nil(t, p.UpdateConfig(func(conf *Config) {
+		conf.MaxActive = 1
+	}))
+	require.Nil(t, p.Put(conn1))
+	_, err = p.Get(context.Background())
+	require.ErrorIs(t, err, ErrWaitGetConnTimeout)
+
+	require.Nil(t, p.Put(conn2))
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	require.Nil(t, p.Put(conn))
+}
+
+// 调小最大等待数量时拒绝超出的请求
+func TestUpdateConfigRejectWaiter(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MaxActive = 1
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := p.Get(context.Background())
+			errs <- err
+		}()
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	require.Nil(t, p.UpdateConfig(func(conf *Config) {
+		conf.MaxWaitConnCount = 1
+	}))
+	require.Equal(t, ErrMaxWaitConnLimit, <-errs)
+	require.Equal(t, 1, p.Stats().WaitQueueLen)
+
+	require.Nil(t, p.Put(conn))
+	require.Nil(t, <-errs)
+}
+
+func TestUpdateConfigIgnoreStaticField(t *testing.T) {
+	conf := makeTestConfig()
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	require.Nil(t, p.UpdateConfig(func(conf *Config) {
+		conf.MinIdle = 3
+		conf.MaxIdle = 6
+		conf.CheckIdleInterval = time.Millisecond * 100
+		conf.Creator = nil
+	}))
+	time.Sleep(time.Millisecond * 100)
+	st := p.Stats()
+	require.Equal(t, 3, st.MinIdle)
+	require.Equal(t, 6, st.MaxIdle)
+	require.Equal(t, 3, st.IdleCount)
+
+	p.Close()
+	require.Equal(t, ErrPoolClosed, p.UpdateConfig(func(conf *Config) {}))
+}
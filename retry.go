@@ -0,0 +1,128 @@
+package connpool
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 首次重试延迟
+	defRetryInitialDelay = time.Second
+	// 最大重试延迟
+	defRetryMaxDelay = time.Second * 30
+	// 重试延迟增长倍数
+	defRetryMultiplier = 2
+	// 重试延迟抖动比例
+	defRetryJitter = 0.2
+	// 最大连续尝试次数
+	defRetryMaxAttempts = 3
+)
+
+// 创建conn失败后的重试策略, 用于补充conn以及等待第一个conn
+type RetryPolicy struct {
+	InitialDelay time.Duration // 首次重试延迟
+	MaxDelay     time.Duration // 最大重试延迟
+	Multiplier   float64       // 每次失败后延迟的增长倍数
+	Jitter       float64       // 延迟随机抖动比例, 取值0~1, 如0.2表示在±20%范围内抖动
+	MaxAttempts  int           // 单次补充的最大连续尝试次数, 达到后放弃并等待下次触发补充, 为0时使用默认值, 小于0表示不限制
+}
+
+func newRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		InitialDelay: defRetryInitialDelay,
+		MaxDelay:     defRetryMaxDelay,
+		Multiplier:   defRetryMultiplier,
+		Jitter:       defRetryJitter,
+		MaxAttempts:  defRetryMaxAttempts,
+	}
+}
+
+func (r *RetryPolicy) check() {
+	if r.InitialDelay < 1 {
+		r.InitialDelay = defRetryInitialDelay
+	}
+	if r.MaxDelay < r.InitialDelay {
+		r.MaxDelay = r.InitialDelay
+	}
+	if r.Multiplier < 1 {
+		r.Multiplier = defRetryMultiplier
+	}
+	if r.Jitter < 0 {
+		r.Jitter = 0
+	}
+	if r.Jitter > 1 {
+		r.Jitter = 1
+	}
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = defRetryMaxAttempts
+	}
+}
+
+// 连续失败fails次后应该等待的时间
+func (r *RetryPolicy) Delay(fails int) time.Duration {
+	if fails < 1 {
+		return 0
+	}
+
+	d := float64(r.InitialDelay) * math.Pow(r.Multiplier, float64(fails-1))
+	if d > float64(r.MaxDelay) {
+		d = float64(r.MaxDelay)
+	}
+	if r.Jitter > 0 {
+		d += d * r.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// 申请一个连接, 失败时按重试策略退避重试
+//
+// 退避时间取决于连接池的连续失败次数而不是本次调用的失败次数, 后端持续不可用时
+// 新触发的补充也需要等待, 延迟会一直增长到 MaxDelay, 直到有conn创建成功
+func (c *ConnectPool) applyConnectRetry() error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		// 一般来说创建失败都是网络或者限流引起的, 立即重新创建极有可能也会失败, 等一会儿可能就好了
+		if d := c.config().Retry.Delay(int(atomic.LoadInt32(&c.createFails))); d > 0 {
+			if !c.sleep(d) {
+				return ErrPoolClosed
+			}
+		}
+
+		err = c.applyConnectLoop()
+		if err == nil || err == ErrPoolClosed {
+			return err
+		}
+		if c.config().Retry.MaxAttempts > 0 && attempt >= c.config().Retry.MaxAttempts {
+			return err
+		}
+	}
+}
+
+// 记录创建结果
+func (c *ConnectPool) recordCreateResult(conn *Conn, err error, cost time.Duration) {
+	c.stats.addCreate(err)
+	if err != nil {
+		atomic.AddInt32(&c.createFails, 1)
+		c.lastCreateErr.Store(&createErrRecord{err: err, t: c.now()})
+		c.obs.OnCreateError(err, cost)
+		return
+	}
+	atomic.StoreInt32(&c.createFails, 0)
+	c.obs.OnCreate(conn, cost)
+	c.lastCreateErr.Store((*createErrRecord)(nil))
+}
+
+// 等待一段时间, 连接池关闭时返回false
+func (c *ConnectPool) sleep(d time.Duration) bool {
+	t := c.config().Clock.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-c.close:
+		return false
+	case <-t.C():
+		return true
+	}
+}
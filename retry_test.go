@@ -0,0 +1,153 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	r := RetryPolicy{
+		InitialDelay: time.Millisecond * 100,
+		MaxDelay:     time.Second,
+		Multiplier:   2,
+	}
+	require.Equal(t, time.Duration(0), r.Delay(0))
+	require.Equal(t, time.Millisecond*100, r.Delay(1))
+	require.Equal(t, time.Millisecond*400, r.Delay(3))
+	require.Equal(t, time.Second, r.Delay(10)) // 不超过最大延迟
+
+	r.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := r.Delay(1)
+		require.True(t, d >= time.Millisecond*50 && d <= time.Millisecond*150)
+	}
+}
+
+// 等待第一个conn时重试
+func TestWaitFirstConnRetry(t *testing.T) {
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.Retry.InitialDelay = time.Millisecond * 10
+	conf.Retry.MaxAttempts = 3
+
+	creatorNum := int32(0)
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt32(&creatorNum, 1) < 3 {
+			return nil, errors.New("")
+		}
+		return testConn{}, nil
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	p.Close()
+
+	// 超过最大尝试次数
+	conf = makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.Retry.InitialDelay = time.Millisecond * 10
+	conf.Retry.MaxAttempts = 3
+	failNum := int32(0)
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&failNum, 1)
+		return nil, errors.New("")
+	}
+	_, err = NewConnectPool(conf)
+	require.NotNil(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&failNum))
+}
+
+// 补充conn失败时退避
+func TestReplenishBackoff(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MinIdle = 1
+	conf.BatchIncrement = 1
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	conf.Retry.InitialDelay = time.Millisecond * 200
+	conf.Retry.Jitter = 0
+	conf.Retry.MaxAttempts = -1
+
+	creatorNum := int32(0)
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt32(&creatorNum, 1) < 3 {
+			return nil, errors.New("")
+		}
+		return testConn{}, nil
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	time.Sleep(time.Millisecond * 100)
+	require.Equal(t, int32(1), atomic.LoadInt32(&creatorNum)) // 失败后等待200ms
+	time.Sleep(time.Millisecond * 200)
+	require.Equal(t, int32(2), atomic.LoadInt32(&creatorNum)) // 再次失败后等待400ms
+	time.Sleep(time.Millisecond * 400)
+	require.Equal(t, int32(3), atomic.LoadInt32(&creatorNum))
+	require.Equal(t, 1, p.Stats().IdleCount)
+}
+
+// 未设置 MaxAttempts 时使用默认值, 不会无限重试
+func TestRetryDefaultMaxAttempts(t *testing.T) {
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.Retry = RetryPolicy{InitialDelay: time.Millisecond}
+	failNum := int32(0)
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&failNum, 1)
+		return nil, errors.New("")
+	}
+	_, err := NewConnectPool(conf)
+	require.NotNil(t, err)
+	require.Equal(t, int32(defRetryMaxAttempts), atomic.LoadInt32(&failNum))
+}
+
+// 退避时间取决于连接池的连续失败次数, 每次补充放弃后再次触发的补充仍然需要等待
+func TestReplenishBackoffAcrossCalls(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MinIdle = 1
+	conf.BatchIncrement = 1
+	conf.CheckIdleInterval = time.Millisecond * 100 // 频繁触发补充
+	conf.Retry.InitialDelay = time.Millisecond * 200
+	conf.Retry.Jitter = 0
+	conf.Retry.MaxAttempts = 1 // 每次补充只尝试1次
+
+	creatorNum := int32(0)
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&creatorNum, 1)
+		return nil, errors.New("")
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	// 依次在 0ms, 300ms(等待200ms), 800ms(等待400ms) 时创建, 不退避时每次检查都会创建
+	time.Sleep(time.Second)
+	n := atomic.LoadInt32(&creatorNum)
+	require.True(t, n >= 2 && n <= 4, n)
+}
+
+// 创建成功后重置连续失败次数
+func TestReplenishBackoffReset(t *testing.T) {
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.Retry.InitialDelay = time.Millisecond * 10
+	conf.Retry.MaxAttempts = 3
+
+	creatorNum := int32(0)
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt32(&creatorNum, 1) < 3 {
+			return nil, errors.New("")
+		}
+		return testConn{}, nil
+	}
+	pool, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer pool.Close()
+	p := pool.(*ConnectPool)
+	require.Equal(t, int32(0), atomic.LoadInt32(&p.createFails))
+}
@@ -0,0 +1,53 @@
+package connpool
+
+import (
+	"context"
+)
+
+// 优雅关闭报告
+type ShutdownReport struct {
+	ClosedIdle int // 关闭的空闲conn数量
+	Leaked     int // 强制关闭时仍未放回的conn数量, 这些conn会在放回时被关闭
+}
+
+// 优雅关闭连接池
+//
+// 立即拒绝新的 Get 请求, 已经在等待的请求会继续等待conn, 当所有请求完成并且所有conn都已放回后关闭连接池.
+// 如果ctx先到期则强制关闭连接池并返回ctx的错误, 报告中会包含仍未放回的conn数量.
+func (c *ConnectPool) Shutdown(ctx context.Context) (ShutdownReport, error) {
+	c.mx.Lock()
+	if c.isClose() {
+		c.mx.Unlock()
+		return ShutdownReport{}, ErrPoolClosed
+	}
+	if !c.draining {
+		c.draining = true
+		c.drained = make(chan struct{})
+		c.checkDrained()
+	}
+	drained := c.drained
+	c.mx.Unlock()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	closedIdle, leaked := c.closePool()
+	return ShutdownReport{ClosedIdle: closedIdle, Leaked: leaked}, err
+}
+
+// 如果正在排空并且已经没有活跃conn和等待请求, 发出排空完成信号, 需要加锁调用
+func (c *ConnectPool) checkDrained() {
+	if !c.draining || c.activeNum > 0 || c.waitList.Len() > 0 || c.activeWaitList.Len() > 0 {
+		return
+	}
+
+	select {
+	case <-c.drained:
+	default:
+		close(c.drained)
+	}
+}
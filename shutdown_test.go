@@ -0,0 +1,105 @@
+package connpool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+
+	conn1, err := p.Get(context.Background())
+	require.Nil(t, err)
+	conn2, err := p.Get(context.Background())
+	require.Nil(t, err)
+
+	drainErr := make(chan error, 1)
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		_, err := p.Get(context.Background()) // 排空时不再接受新的请求
+		drainErr <- err
+		p.Put(conn1)
+		p.Put(conn2)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	report, err := p.Shutdown(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 0, report.Leaked)
+	require.True(t, report.ClosedIdle >= 2)
+	require.Equal(t, ErrPoolClosed, <-drainErr)
+
+	_, err = p.Get(context.Background())
+	require.Equal(t, ErrPoolClosed, err)
+	_, err = p.Shutdown(ctx)
+	require.Equal(t, ErrPoolClosed, err)
+}
+
+// 排空时等待中的请求继续完成
+func TestShutdownWaitReq(t *testing.T) {
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	conf.MaxActive = 1
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := p.Get(context.Background()) // 等待活跃锁
+		if err == nil {
+			time.Sleep(time.Millisecond * 100)
+			err = p.Put(conn)
+		}
+		done <- err
+	}()
+
+	go func() {
+		time.Sleep(time.Millisecond * 300)
+		p.Put(conn)
+	}()
+
+	time.Sleep(time.Millisecond * 100) // 让等待请求先进入队列
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	report, err := p.Shutdown(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 0, report.Leaked)
+	require.Nil(t, <-done)
+}
+
+// ctx到期时强制关闭
+func TestShutdownTimeout(t *testing.T) {
+	conf := makeTestConfig()
+	conf.WaitFirstConn = true
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+
+	closeNum := 0
+	conf.ConnClose = func(conn *Conn) {
+		closeNum++
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	report, err := p.Shutdown(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Equal(t, 1, report.Leaked)
+
+	// 强制关闭后放回的conn会被关闭
+	closeNum = 0
+	p.Put(conn)
+	require.Equal(t, 1, closeNum)
+}
@@ -0,0 +1,151 @@
+package connpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// conn被释放的原因
+type CloseReason int
+
+const (
+	CloseReasonInvalid     CloseReason = iota // ValidConnected 校验失败
+	CloseReasonLifetime                       // 超过最大存活时间
+	CloseReasonIdleTimeout                    // 空闲超时
+	CloseReasonNeedless                       // 超过最大闲置被缩容
+	CloseReasonPoolClosed                     // 连接池已关闭
+	CloseReasonUnhealthy                      // 健康检查失败
+	CloseReasonLeaked                         // 泄漏后被回收
+	CloseReasonDiscard                        // 被调用者丢弃
+
+	closeReasonCount
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseReasonInvalid:
+		return "invalid"
+	case CloseReasonLifetime:
+		return "lifetime"
+	case CloseReasonIdleTimeout:
+		return "idle_timeout"
+	case CloseReasonNeedless:
+		return "needless"
+	case CloseReasonPoolClosed:
+		return "pool_closed"
+	case CloseReasonUnhealthy:
+		return "unhealthy"
+	case CloseReasonLeaked:
+		return "leaked"
+	case CloseReasonDiscard:
+		return "discard"
+	}
+	return "unknown"
+}
+
+// 连接池统计快照
+type Stats struct {
+	IdleCount          int          // 空闲conn数量
+	ActiveCount        int          // 活跃conn数量, 即已被取出未放回的conn
+	ConnectingCount    int          // 正在创建的conn数量
+	WaitQueueLen       int          // 未取到活跃锁的等待请求数量
+	ActiveWaitQueueLen int          // 已经取到活跃锁的等待请求数量
+	BreakerState       BreakerState // 熔断器状态
+	MinIdle            int          // 当前配置的最小闲置
+	MaxIdle            int          // 当前配置的最大闲置
+	MaxActive          int          // 当前配置的最大活跃连接数, 小于1表示不限制
+
+	GetCount        int64                 // 累计获取次数
+	WaitCount       int64                 // 累计需要等待的获取次数
+	WaitDuration    time.Duration         // 累计等待时间
+	TimeoutCount    int64                 // 累计等待超时次数
+	CreateCount     int64                 // 累计创建conn成功次数
+	CreateFailCount int64                 // 累计创建conn失败次数
+	CloseCount      map[CloseReason]int64 // 按原因统计的累计释放conn次数
+}
+
+// 将s的数量和累计计数加到st上, 不包括配置的限制以及熔断器状态
+func (st *Stats) merge(s Stats) {
+	st.IdleCount += s.IdleCount
+	st.ActiveCount += s.ActiveCount
+	st.ConnectingCount += s.ConnectingCount
+	st.WaitQueueLen += s.WaitQueueLen
+	st.ActiveWaitQueueLen += s.ActiveWaitQueueLen
+	st.GetCount += s.GetCount
+	st.WaitCount += s.WaitCount
+	st.WaitDuration += s.WaitDuration
+	st.TimeoutCount += s.TimeoutCount
+	st.CreateCount += s.CreateCount
+	st.CreateFailCount += s.CreateFailCount
+	if st.CloseCount == nil {
+		st.CloseCount = make(map[CloseReason]int64, closeReasonCount)
+	}
+	for reason, n := range s.CloseCount {
+		st.CloseCount[reason] += n
+	}
+}
+
+// 累计计数器, 全部通过atomic操作
+type poolStats struct {
+	getCount        int64
+	waitCount       int64
+	waitDuration    int64
+	timeoutCount    int64
+	createCount     int64
+	createFailCount int64
+	closeCount      [closeReasonCount]int64
+}
+
+func (s *poolStats) addGet() {
+	atomic.AddInt64(&s.getCount, 1)
+}
+
+func (s *poolStats) addWait(d time.Duration, timeout bool) {
+	atomic.AddInt64(&s.waitCount, 1)
+	atomic.AddInt64(&s.waitDuration, int64(d))
+	if timeout {
+		atomic.AddInt64(&s.timeoutCount, 1)
+	}
+}
+
+func (s *poolStats) addCreate(err error) {
+	if err != nil {
+		atomic.AddInt64(&s.createFailCount, 1)
+		return
+	}
+	atomic.AddInt64(&s.createCount, 1)
+}
+
+func (s *poolStats) addClose(reason CloseReason) {
+	atomic.AddInt64(&s.closeCount[reason], 1)
+}
+
+// 获取统计快照
+func (c *ConnectPool) Stats() Stats {
+	conf := c.config()
+	c.mx.Lock()
+	st := Stats{
+		MinIdle:            conf.MinIdle,
+		MaxIdle:            conf.MaxIdle,
+		MaxActive:          conf.MaxActive,
+		IdleCount:          c.connList.Len(),
+		ActiveCount:        c.activeNum,
+		ConnectingCount:    c.connectingCount,
+		WaitQueueLen:       c.waitList.Len(),
+		ActiveWaitQueueLen: c.activeWaitList.Len(),
+	}
+	c.mx.Unlock()
+
+	st.BreakerState = c.breaker.getState()
+	st.GetCount = atomic.LoadInt64(&c.stats.getCount)
+	st.WaitCount = atomic.LoadInt64(&c.stats.waitCount)
+	st.WaitDuration = time.Duration(atomic.LoadInt64(&c.stats.waitDuration))
+	st.TimeoutCount = atomic.LoadInt64(&c.stats.timeoutCount)
+	st.CreateCount = atomic.LoadInt64(&c.stats.createCount)
+	st.CreateFailCount = atomic.LoadInt64(&c.stats.createFailCount)
+	st.CloseCount = make(map[CloseReason]int64, closeReasonCount)
+	for i := CloseReason(0); i < closeReasonCount; i++ {
+		st.CloseCount[i] = atomic.LoadInt64(&c.stats.closeCount[i])
+	}
+	return st
+}
@@ -0,0 +1,42 @@
+package connpool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MinIdle = 1
+	conf.MaxActive = 1
+	conf.WaitTimeout = time.Millisecond * 100
+	conf.CheckIdleInterval = time.Minute // 将自动补足时间变长
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+
+	time.Sleep(time.Millisecond * 200) // 等待初始化填充conn
+	st := p.Stats()
+	require.Equal(t, 1, st.IdleCount)
+	require.Equal(t, int64(1), st.CreateCount)
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	_, err = p.Get(context.Background()) // 达到最大活跃数, 等待超时
+	require.Equal(t, ErrWaitGetConnTimeout, err)
+
+	st = p.Stats()
+	require.Equal(t, 1, st.ActiveCount)
+	require.Equal(t, int64(2), st.GetCount)
+	require.Equal(t, int64(1), st.WaitCount)
+	require.Equal(t, int64(1), st.TimeoutCount)
+	require.True(t, st.WaitDuration >= conf.WaitTimeout)
+
+	p.Put(conn)
+	p.Close()
+	st = p.Stats()
+	require.Equal(t, 0, st.ActiveCount)
+	require.Equal(t, int64(1), st.CloseCount[CloseReasonPoolClosed])
+}
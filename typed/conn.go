@@ -0,0 +1,71 @@
+package typed
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zlyuancn/connpool"
+)
+
+// 类型安全的conn, 与 connpool.Conn 共享内存布局, 相互转换无额外开销
+type Conn[T any] connpool.Conn
+
+// 获取通过 Creator 创建的真实连接, 真实连接不是 T 时panic
+func (c *Conn[T]) GetConn() T {
+	raw := c.Raw().GetConn()
+	if raw == nil {
+		var zero T
+		return zero
+	}
+	v, ok := raw.(T)
+	if !ok {
+		panic(fmt.Sprintf("typed: conn的类型为%T, 不是%s", raw, typeName[T]()))
+	}
+	return v
+}
+
+// 检查真实连接的类型是否为 T
+func checkConnType[T any](conn *connpool.Conn) error {
+	raw := conn.GetConn()
+	if raw == nil {
+		return nil
+	}
+	if _, ok := raw.(T); !ok {
+		return fmt.Errorf("typed: conn的类型为%T, 不是%s", raw, typeName[T]())
+	}
+	return nil
+}
+
+func typeName[T any]() string {
+	return fmt.Sprintf("%T", (*T)(nil))[1:]
+}
+
+// 获取conn的唯一id
+func (c *Conn[T]) ID() uint64 { return c.Raw().ID() }
+
+// 获取conn的创建时间
+func (c *Conn[T]) CreatedAt() time.Time { return c.Raw().CreatedAt() }
+
+// 获取conn最后一次被取出或放回的时间
+func (c *Conn[T]) LastUsedAt() time.Time { return c.Raw().LastUsedAt() }
+
+// 获取conn被取出的次数
+func (c *Conn[T]) UseCount() int64 { return c.Raw().UseCount() }
+
+// 设置用户属性
+func (c *Conn[T]) SetAttr(key string, value interface{}) { c.Raw().SetAttr(key, value) }
+
+// 获取用户属性
+func (c *Conn[T]) GetAttr(key string) (interface{}, bool) { return c.Raw().GetAttr(key) }
+
+// 删除用户属性
+func (c *Conn[T]) DelAttr(key string) { c.Raw().DelAttr(key) }
+
+// 获取原始的 connpool.Conn
+func (c *Conn[T]) Raw() *connpool.Conn {
+	return (*connpool.Conn)(c)
+}
+
+func wrapConn[T any](conn *connpool.Conn) *Conn[T] {
+	return (*Conn[T])(conn)
+}
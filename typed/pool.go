@@ -0,0 +1,141 @@
+package typed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/zlyuancn/connpool"
+)
+
+type IConnectPool[T any] interface {
+	// 获取
+	Get(ctx context.Context) (*Conn[T], error)
+	// 回收, 参考 connpool.IConnectPool
+	Put(conn *Conn[T]) error
+	// 丢弃已损坏的conn, 参考 connpool.IConnectPool
+	Discard(conn *Conn[T], reason error) error
+	// 关闭连接池
+	Close()
+	// 优雅关闭连接池, 参考 connpool.IConnectPool
+	Shutdown(ctx context.Context) (connpool.ShutdownReport, error)
+	// 获取统计快照
+	Stats() connpool.Stats
+	// 运行时修改配置, 参考 connpool.ConnectPool.UpdateConfig
+	UpdateConfig(update func(conf *connpool.Config)) error
+	// 预热, 参考 connpool.IConnectPool
+	Warmup(ctx context.Context, n int) error
+	// 缩容, 参考 connpool.IConnectPool
+	Shrink(n int) int
+}
+
+// 创造者
+type Creator[T any] func(ctx context.Context) (T, error)
+
+// 关闭方式, 参考 connpool.ConnClose
+type ConnClose[T any] func(conn *Conn[T])
+
+// 检查连接是否有效, 如果有效返回true
+type ValidConnected[T any] func(conn *Conn[T]) bool
+
+type Config[T any] struct {
+	connpool.Config // 连接池配置, 其中的 Creator, ConnClose, ValidConnected 由本包接管
+	Creator         Creator[T]
+	ConnClose       ConnClose[T]
+	ValidConnected  ValidConnected[T]
+}
+
+func NewConfig[T any]() *Config[T] {
+	return &Config[T]{
+		Config: *connpool.NewConfig(),
+	}
+}
+
+// 类型安全的连接池, 基于 connpool.IConnectPool 实现
+type ConnectPool[T any] struct {
+	pool connpool.IConnectPool
+}
+
+func NewConnectPool[T any](conf *Config[T]) (IConnectPool[T], error) {
+	if conf.Creator == nil {
+		return nil, errors.New("配置检查失败: 未设置 Creator")
+	}
+	if conf.ConnClose == nil {
+		return nil, errors.New("配置检查失败: 未设置 ConnClose")
+	}
+
+	// 回调在调用时才读取, 与 connpool 一样允许创建连接池后修改
+	conf.Config.Creator = func(ctx context.Context) (interface{}, error) {
+		return conf.Creator(ctx)
+	}
+	conf.Config.ConnClose = func(conn *connpool.Conn) {
+		conf.ConnClose(wrapConn[T](conn))
+	}
+	conf.Config.ValidConnected = func(conn *connpool.Conn) bool {
+		if conf.ValidConnected == nil {
+			return true
+		}
+		return conf.ValidConnected(wrapConn[T](conn))
+	}
+
+	pool, err := connpool.NewConnectPool(&conf.Config)
+	if err != nil {
+		return nil, fmt.Errorf("创建连接池失败: %v", err)
+	}
+	return Wrap[T](pool), nil
+}
+
+// 包装一个已有的连接池, 调用者需保证其 Creator 创建的连接类型为 T
+func Wrap[T any](pool connpool.IConnectPool) *ConnectPool[T] {
+	return &ConnectPool[T]{pool: pool}
+}
+
+func (p *ConnectPool[T]) Get(ctx context.Context) (*Conn[T], error) {
+	conn, err := p.pool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 通过 Wrap 包装的连接池可能创建了其它类型的连接
+	if err = checkConnType[T](conn); err != nil {
+		_ = p.pool.Discard(conn, err)
+		return nil, err
+	}
+	return wrapConn[T](conn), nil
+}
+
+func (p *ConnectPool[T]) Put(conn *Conn[T]) error {
+	return p.pool.Put(conn.Raw())
+}
+
+func (p *ConnectPool[T]) Discard(conn *Conn[T], reason error) error {
+	return p.pool.Discard(conn.Raw(), reason)
+}
+
+func (p *ConnectPool[T]) Close() {
+	p.pool.Close()
+}
+
+func (p *ConnectPool[T]) Shutdown(ctx context.Context) (connpool.ShutdownReport, error) {
+	return p.pool.Shutdown(ctx)
+}
+
+func (p *ConnectPool[T]) Stats() connpool.Stats {
+	return p.pool.Stats()
+}
+
+func (p *ConnectPool[T]) UpdateConfig(update func(conf *connpool.Config)) error {
+	return p.pool.UpdateConfig(update)
+}
+
+func (p *ConnectPool[T]) Warmup(ctx context.Context, n int) error {
+	return p.pool.Warmup(ctx, n)
+}
+
+func (p *ConnectPool[T]) Shrink(n int) int {
+	return p.pool.Shrink(n)
+}
+
+// 获取底层的连接池
+func (p *ConnectPool[T]) Unwrap() connpool.IConnectPool {
+	return p.pool
+}
@@ -0,0 +1,98 @@
+package typed
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zlyuancn/connpool"
+)
+
+type testConn struct {
+	id int
+}
+
+func makeTestConfig() *Config[*testConn] {
+	conf := NewConfig[*testConn]()
+	conf.WaitFirstConn = true
+	conf.Creator = func(ctx context.Context) (*testConn, error) { return &testConn{id: 1}, nil }
+	conf.ConnClose = func(conn *Conn[*testConn]) {}
+	return conf
+}
+
+func TestGet(t *testing.T) {
+	conf := makeTestConfig()
+	validNum := int32(0)
+	conf.ValidConnected = func(conn *Conn[*testConn]) bool {
+		atomic.AddInt32(&validNum, 1)
+		return conn.GetConn().id == 1
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 1, conn.GetConn().id)
+	p.Put(conn)
+	require.True(t, atomic.LoadInt32(&validNum) > 0)
+}
+
+func TestCloseConn(t *testing.T) {
+	conf := makeTestConfig()
+	closeNum := int32(0)
+	conf.ConnClose = func(conn *Conn[*testConn]) {
+		if conn.GetConn().id == 1 {
+			atomic.AddInt32(&closeNum, 1)
+		}
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	p.Close()
+	require.True(t, atomic.LoadInt32(&closeNum) > 0)
+}
+
+func TestCreatorErr(t *testing.T) {
+	conf := makeTestConfig()
+	conf.Creator = func(ctx context.Context) (*testConn, error) { return nil, errors.New("") }
+	_, err := NewConnectPool(conf)
+	require.NotNil(t, err)
+
+	conf.Creator = nil
+	_, err = NewConnectPool(conf)
+	require.NotNil(t, err)
+}
+
+func TestWrap(t *testing.T) {
+	conf := connpool.NewConfig()
+	conf.Creator = func(ctx context.Context) (interface{}, error) { return &testConn{id: 2}, nil }
+	conf.ConnClose = func(conn *connpool.Conn) {}
+	pool, err := connpool.NewConnectPool(conf)
+	require.Nil(t, err)
+
+	p := Wrap[*testConn](pool)
+	defer p.Close()
+	conn, err := p.Get(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 2, conn.GetConn().id)
+	require.Equal(t, pool, p.Unwrap())
+}
+
+// 包装的连接池创建了其它类型的连接
+func TestWrapWrongType(t *testing.T) {
+	conf := connpool.NewConfig()
+	conf.Creator = func(ctx context.Context) (interface{}, error) { return "not testConn", nil }
+	conf.ConnClose = func(conn *connpool.Conn) {}
+	pool, err := connpool.NewConnectPool(conf)
+	require.Nil(t, err)
+
+	p := Wrap[*testConn](pool)
+	defer p.Close()
+	_, err = p.Get(context.Background())
+	require.EqualError(t, err, "typed: conn的类型为string, 不是*typed.testConn")
+	require.Equal(t, int64(1), p.Stats().CloseCount[connpool.CloseReasonDiscard])
+
+	require.Panics(t, func() { wrapConn[*testConn](connpool.NewConn("x")).GetConn() })
+}
@@ -3,13 +3,47 @@ package connpool
 import (
 	"container/list"
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type waitReq struct {
 	ch            chan *Conn
 	e             *list.Element
-	hasActiveLock bool // 是否已获得活跃锁
+	hasActiveLock bool      // 是否已获得活跃锁
+	pos           int       // 加入时在等待队列中的位置, 从1开始
+	fresh         bool      // 交付的conn是否为第一次被取出
+	err           error     // 被拒绝的原因, 被拒绝时会收到nil
+	priority      int       // 优先级, 越大越优先
+	enqueue       time.Time // 加入等待队列的时间
+}
+
+// 获取conn超时错误, 包含超时前最后一次创建conn失败的错误
+type WaitTimeoutError struct {
+	LastCreateErr  error     // 最后一次创建conn失败的错误
+	LastCreateTime time.Time // 最后一次创建conn失败的时间
+}
+
+func (e *WaitTimeoutError) Error() string {
+	return fmt.Sprintf("%v, 最后一次创建连接失败于 %s: %v",
+		ErrWaitGetConnTimeout, e.LastCreateTime.Format("2006-01-02 15:04:05.000"), e.LastCreateErr)
+}
+
+func (e *WaitTimeoutError) Is(target error) bool {
+	return target == ErrWaitGetConnTimeout
+}
+
+func (e *WaitTimeoutError) Unwrap() error {
+	return e.LastCreateErr
+}
+
+// 创建conn失败的记录
+type createErrRecord struct {
+	err error
+	t   time.Time
 }
 
 var waitReqPool = &sync.Pool{
@@ -34,10 +68,21 @@ func (c *ConnectPool) useConn(conn *Conn) bool {
 		return false
 	}
 
-	// 先进先出
-	e := c.activeWaitList.Front()
-	req := c.activeWaitList.Remove(e).(*waitReq)
-	req.ch <- conn // 必然能放入
+	// 优先级最高的先获取, 优先级相同时先进先出
+	req := c.removeWaitReq(c.activeWaitList, c.bestWaitReq(c.activeWaitList))
+	req.fresh = conn.markBorrowed() // 必须在交付前设置, 交付后由等待者读取
+	req.ch <- conn                  // 必然能放入
+	c.activeNum++                   // 交付时即计入活跃, 如果waitReq已超时会在取回conn时-1
+	return true
+}
+
+// 尝试获取一个活跃锁, MaxActive 小于1时总是成功
+func (c *ConnectPool) tryActiveLock() bool {
+	max := c.config().MaxActive
+	if max > 0 && c.activeLockNum >= max {
+		return false
+	}
+	c.activeLockNum++
 	return true
 }
 
@@ -45,75 +90,140 @@ func (c *ConnectPool) useConn(conn *Conn) bool {
   如果有等待获取活跃锁的waitReq, 则直接给这个waitReq
 */
 func (c *ConnectPool) putActiveLock() {
-	if c.waitList.Len() == 0 {
-		if c.conf.MaxActive > 0 { // 否则释放一个锁
-			c.activeLock <- struct{}{}
-		}
-		return
-	}
-
-	// 从未取得锁的等待队列中取出一个等待请求, 放入已获取锁等待请求列表
-	e := c.waitList.Front()
-	req := c.waitList.Remove(e).(*waitReq)
+	c.activeLockNum--
+	c.grantActiveLock()
+}
 
-	e = c.activeWaitList.PushBack(req)
-	req.e = e
-	req.hasActiveLock = true
+// 将空闲的活跃锁交给未取到活跃锁的waitReq, 返回交出的数量
+func (c *ConnectPool) grantActiveLock() int {
+	n := 0
+	for c.waitList.Len() > 0 && c.tryActiveLock() {
+		// 从未取得锁的等待队列中取出一个等待请求, 放入已获取锁等待请求列表
+		e := c.bestWaitReq(c.waitList)
+		req := c.waitList.Remove(e).(*waitReq)
+
+		e = c.activeWaitList.PushBack(req)
+		req.e = e
+		req.hasActiveLock = true
+		n++
+	}
+	return n
 }
 
 // 添加等待req
-func (c *ConnectPool) addWaitReq(hasActiveLock bool) (*waitReq, error) {
+func (c *ConnectPool) addWaitReq(hasActiveLock bool, priority int) (*waitReq, error) {
 	l := c.waitList
 
 	if hasActiveLock {
 		l = c.activeWaitList
-	} else if c.conf.MaxWaitConnCount > 0 && c.waitList.Len() >= c.conf.MaxWaitConnCount { // 检查最大等待数量
+	} else if c.config().MaxWaitConnCount > 0 && c.waitList.Len() >= c.config().MaxWaitConnCount { // 检查最大等待数量
 		return nil, ErrMaxWaitConnLimit
 	}
 
 	req := waitReqPool.Get().(*waitReq)
 	req.hasActiveLock = hasActiveLock
+	req.err = nil
+	req.priority = priority
+	req.enqueue = c.now()
+	if priority != 0 {
+		c.priorityWaitNum++
+	}
 	reqElement := l.PushBack(req) // 放入末尾, 先进先出
 	req.e = reqElement
+	req.pos = l.Len()
 	return req, nil
 }
 
+// 从等待列表中移除waitReq
+func (c *ConnectPool) removeWaitReq(l *list.List, e *list.Element) *waitReq {
+	req := l.Remove(e).(*waitReq)
+	if req.priority != 0 {
+		c.priorityWaitNum--
+	}
+	return req
+}
+
 // waitReq等待获取到conn, 一旦成功取到conn则活跃计数+1
 func (c *ConnectPool) waitReqGetConnLoop(ctx context.Context, req *waitReq) (conn *Conn, err error) {
+	start := c.now()
+	defer func() {
+		c.stats.addWait(c.since(start), errors.Is(err, ErrWaitGetConnTimeout))
+	}()
+
 	// 等待conn
-	ctxWait, cancel := context.WithTimeout(ctx, c.conf.WaitTimeout)
-	defer cancel()
+	t := c.config().Clock.NewTimer(c.config().WaitTimeout)
+	defer t.Stop()
 
 	select {
 	case <-c.close: // 已关闭
 		err = ErrPoolClosed
-	case <-ctxWait.Done(): // 超时
-		err = ErrWaitGetConnTimeout
+	case <-t.C(): // 超时
+		err = c.waitTimeoutErr()
+	case <-ctx.Done(): // 调用者取消也视为超时
+		err = c.waitTimeoutErr()
 	case conn = <-req.ch:
-		c.activeNum++
+		if conn == nil { // 被拒绝, 已经从等待队列中移除
+			err = req.err
+			waitReqPool.Put(req)
+			return nil, err
+		}
+		c.obs.OnGet(ctx, conn, GetInfo{Waited: c.since(start), QueuePosition: req.pos, Fresh: req.fresh})
 		waitReqPool.Put(req)
 		return conn, nil
 	}
 
+	if errors.Is(err, ErrWaitGetConnTimeout) {
+		c.obs.OnWaitTimeout(ctx, GetInfo{Waited: c.since(start), QueuePosition: req.pos})
+	}
+
 	// 这里可能已经被 useConn 取出并已经放入了 conn, 所以需要再尝试一下
+	var late *Conn
 	c.mx.Lock()
 	select {
-	case conn = <-req.ch:
+	case late = <-req.ch:
+		if late != nil { // 为nil表示已被拒绝并从等待队列中移除
+			c.activeNum--
+			late.setState(connStateIdle)
+			atomic.AddInt64(&late.useCount, -1)
+		}
 	default:
 		if req.hasActiveLock {
-			c.activeWaitList.Remove(req.e)
+			c.removeWaitReq(c.activeWaitList, req.e)
 		} else {
-			c.waitList.Remove(req.e)
+			c.removeWaitReq(c.waitList, req.e)
 		}
 	}
 	if req.hasActiveLock {
 		c.putActiveLock() // 将活跃锁交出去
 	}
+	c.checkDrained()
 	c.mx.Unlock()
 
-	if conn != nil {
-		c.autoPutConn(conn)
+	if late != nil {
+		c.autoPutConn(late)
 	}
 	waitReqPool.Put(req)
-	return
+	return nil, err
+}
+
+// 拒绝超过最大等待数量的waitReq, 优先级最低的先被拒绝, 优先级相同时后加入的先被拒绝
+func (c *ConnectPool) rejectExcessWaitReq() {
+	max := c.config().MaxWaitConnCount
+	for max > 0 && c.waitList.Len() > max {
+		req := c.removeWaitReq(c.waitList, c.worstWaitReq(c.waitList))
+		req.err = ErrMaxWaitConnLimit
+		req.ch <- nil // 必然能放入
+	}
+}
+
+// 生成等待超时错误, 如果最后一次创建conn失败了则包含其错误
+func (c *ConnectPool) waitTimeoutErr() error {
+	record, _ := c.lastCreateErr.Load().(*createErrRecord)
+	if record == nil {
+		return ErrWaitGetConnTimeout
+	}
+	return &WaitTimeoutError{
+		LastCreateErr:  record.err,
+		LastCreateTime: record.t,
+	}
 }
@@ -0,0 +1,124 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 预热错误, 包含预热时每个创建失败的错误
+type WarmupError struct {
+	Created int     // 创建成功的数量
+	Errs    []error // 创建失败的错误
+}
+
+func (e *WarmupError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("预热成功%d个conn, 失败%d个: %s", e.Created, len(e.Errs), strings.Join(msgs, "; "))
+}
+
+// 供 go1.20 及以上版本的 errors.Is 和 errors.As 使用
+func (e *WarmupError) Unwrap() []error {
+	return e.Errs
+}
+
+// 任意一个创建失败的错误匹配target时返回true, 使低于 go1.20 的版本也能通过 errors.Is 判断
+func (e *WarmupError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// 将第一个匹配target的创建失败的错误赋值给target, 使低于 go1.20 的版本也能通过 errors.As 获取
+func (e *WarmupError) As(target interface{}) bool {
+	for _, err := range e.Errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// 预热, 同步创建conn直到空闲conn达到n个, n不会超过 MaxIdle 以及 MaxActive
+//
+// 正在创建的conn也会计入, 所有创建完成后返回, 存在创建失败时返回 *WarmupError
+func (c *ConnectPool) Warmup(ctx context.Context, n int) error {
+	if c.isClose() {
+		return ErrPoolClosed
+	}
+
+	conf := c.config()
+	if n > conf.MaxIdle {
+		n = conf.MaxIdle
+	}
+	if conf.MaxActive > 0 && n > conf.MaxActive {
+		n = conf.MaxActive
+	}
+
+	c.mx.Lock()
+	need := n - c.connList.Len() - c.connectingCount
+	if need < 1 {
+		c.mx.Unlock()
+		return nil
+	}
+	c.connectingCount += need
+	c.mx.Unlock()
+
+	var mx sync.Mutex
+	var wg sync.WaitGroup
+	warmupErr := &WarmupError{}
+	for i := 0; i < need; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.applyConnect(ctx)
+
+			mx.Lock()
+			if err != nil {
+				warmupErr.Errs = append(warmupErr.Errs, err)
+			} else {
+				warmupErr.Created++
+			}
+			mx.Unlock()
+
+			c.mx.Lock()
+			c.connectingCount--
+			c.mx.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(warmupErr.Errs) > 0 {
+		return warmupErr
+	}
+	return nil
+}
+
+// 缩容, 主动释放最多n个空闲conn, 返回释放的数量
+//
+// 根据 IdleStrategy 选择释放的conn: 后进先出和先进先出时优先释放空闲最久的conn, 按创建时间取出时优先释放创建最早的conn.
+// 空闲conn少于 MinIdle 时会在下次检查空闲时补充, 如需长期缩容请通过 UpdateConfig 调小 MinIdle
+func (c *ConnectPool) Shrink(n int) int {
+	c.mx.Lock()
+	shrink := 0
+	var conns []*Conn
+	for shrink < n && c.connList.Len() > 0 {
+		conn := c.connList.Remove(c.evictCandidate()).(*Conn)
+		conns = append(conns, conn)
+		shrink++
+	}
+	c.mx.Unlock()
+
+	for _, conn := range conns {
+		c.closeConn(conn, CloseReasonNeedless)
+	}
+	return shrink
+}
@@ -0,0 +1,73 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmup(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MinIdle = 2
+	conf.MaxIdle = 6
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	time.Sleep(time.Millisecond * 100) // 等待初始conn创建完毕
+	require.Nil(t, p.Warmup(context.Background(), 10))
+	require.Equal(t, 6, p.Stats().IdleCount) // 不超过 MaxIdle
+
+	require.Nil(t, p.Warmup(context.Background(), 3)) // 已经足够
+	require.Equal(t, 6, p.Stats().IdleCount)
+
+	require.Equal(t, 4, p.Shrink(4))
+	st := p.Stats()
+	require.Equal(t, 2, st.IdleCount)
+	require.Equal(t, int64(4), st.CloseCount[CloseReasonNeedless])
+	require.Equal(t, 2, p.Shrink(10))
+}
+
+func TestWarmupErr(t *testing.T) {
+	conf := makeTestConfig()
+	conf.MinIdle = 1
+	conf.MaxIdle = 4
+	conf.MaxActive = 3
+	conf.CheckIdleInterval = time.Minute
+	var n int32
+	createErr := errors.New("connection refused")
+	conf.Creator = func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt32(&n, 1)%2 == 0 {
+			return nil, createErr
+		}
+		return testConn{}, nil
+	}
+	p, err := NewConnectPool(conf)
+	require.Nil(t, err)
+	defer p.Close()
+
+	time.Sleep(time.Millisecond * 100)
+	err = p.Warmup(context.Background(), 4) // 不超过 MaxActive
+	var warmupErr *WarmupError
+	require.True(t, errors.As(err, &warmupErr))
+	require.Equal(t, 1, warmupErr.Created)
+	require.Equal(t, []error{createErr}, warmupErr.Errs)
+	require.True(t, warmupErr.Is(createErr)) // 不依赖 Unwrap() []error
+	require.False(t, warmupErr.Is(ErrPoolClosed))
+	var target testWarmupErr
+	require.False(t, warmupErr.As(&target))
+	require.True(t, (&WarmupError{Errs: []error{createErr, testWarmupErr{"dial"}}}).As(&target))
+	require.Equal(t, "dial", target.op)
+	require.Equal(t, 2, p.Stats().IdleCount)
+
+	p.Close()
+	require.Equal(t, ErrPoolClosed, p.Warmup(context.Background(), 4))
+}
+
+type testWarmupErr struct{ op string }
+
+func (e testWarmupErr) Error() string { return e.op }